@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
@@ -14,24 +15,30 @@ type AnswerRequest struct {
 	Score  int    `json:"score"`
 }
 type SubtaskRequest struct {
-	Name        string          `json:"name"`
-	DisplayName string          `json:"display_name"`
-	Statement   string          `json:"statement"`
-	Answers     []AnswerRequest `json:"answers"`
+	Name         string          `json:"name"`
+	DisplayName  string          `json:"display_name"`
+	Statement    string          `json:"statement"`
+	Answers      []AnswerRequest `json:"answers"`
+	Hints        []HintRequest   `json:"hints"`
+	AttemptLimit int             `json:"attempt_limit"` // 0 の場合は制限なし
+	Checker      string          `json:"checker"`       // 空の場合は問題の checker を使う
+	MaxScore     int             `json:"max_score"`     // checker で判定する場合の満点
 }
 type CreateTaskRequest struct {
-	Name            string           `json:"name"`
-	DisplayName     string           `json:"display_name"`
-	Statement       string           `json:"statement"`
-	SubmissionLimit int              `json:"submission_limit"`
-	Subtasks        []SubtaskRequest `json:"subtasks"`
+	Name            string            `json:"name"`
+	DisplayName     string            `json:"display_name"`
+	Statement       string            `json:"statement"`
+	SubmissionLimit int               `json:"submission_limit"`
+	Subtasks        []SubtaskRequest  `json:"subtasks"`
+	Cooldowns       []CooldownRequest `json:"cooldowns"`
+	DedupeAnswers   bool              `json:"dedupe_answers"`
+	Checker         string            `json:"checker"` // 空の場合は完全一致で判定する
+	Decay           *DecayPolicy      `json:"decay"`   // 省略した場合は減衰しない
+	Dynamic         *DynamicScoring   `json:"dynamic"` // 省略した場合は動的配点にしない
 }
 
-// POST /api/admin/createtask
-func createTaskHandler(c echo.Context) error {
-	ctx := c.Request().Context()
-	defer c.Request().Body.Close()
-
+// admin としてログインしているか確認する
+func verifyAdminSession(c echo.Context) error {
 	if err := verifyUserSession(c); err != nil {
 		return err
 	}
@@ -42,6 +49,17 @@ func createTaskHandler(c echo.Context) error {
 	if username != "admin" {
 		return echo.NewHTTPError(http.StatusUnauthorized, "not admin")
 	}
+	return nil
+}
+
+// POST /api/admin/createtask
+func createTaskHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyAdminSession(c); err != nil {
+		return err
+	}
 
 	req := CreateTaskRequest{}
 	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
@@ -54,15 +72,43 @@ func createTaskHandler(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
+	admin := User{}
+	if err := tx.GetContext(ctx, &admin, "SELECT * FROM users WHERE name = ?", "admin"); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+	}
+	now := time.Now()
+
+	// 書き込みの前にリクエストを検証し、問題があればまとめて返す
+	validationerrors := validatecreatetaskrequest(req)
+
 	task := Task{}
 	err = tx.GetContext(ctx, &task, "SELECT * FROM tasks WHERE name = ?", req.Name)
 	if err == nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "task already exists")
+		validationerrors = append(validationerrors, ValidationError{
+			Field:   "name",
+			Code:    "already_exists",
+			Message: "task already exists",
+		})
 	} else if err != sql.ErrNoRows {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get task: "+err.Error())
 	}
-	
-	if _, err := tx.ExecContext(ctx, "INSERT INTO tasks (name, display_name, statement, submission_limit) VALUES (?, ?, ?, ?)", req.Name, req.DisplayName, req.Statement, req.SubmissionLimit); err != nil {
+
+	if len(validationerrors) > 0 {
+		return c.JSON(http.StatusBadRequest, ValidationErrorResponse{
+			Message: "invalid task",
+			Errors:  validationerrors,
+		})
+	}
+
+	decay := DecayPolicy{Type: decaynone}
+	if req.Decay != nil {
+		decay = *req.Decay
+	}
+	dynamic := DynamicScoring{}
+	if req.Dynamic != nil {
+		dynamic = *req.Dynamic
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO tasks (name, display_name, statement, submission_limit, dedupe_answers, checker, decay_type, decay_interval_minutes, decay_percent, decay_floor_percent, dynamic_initial, dynamic_minimum, dynamic_decay) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", req.Name, req.DisplayName, req.Statement, req.SubmissionLimit, req.DedupeAnswers, req.Checker, decay.Type, decay.IntervalMinutes, decay.Percent, decay.FloorPercent, dynamic.Initial, dynamic.Minimum, dynamic.Decay); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert task: "+err.Error())
 	}
 	var taskID int
@@ -70,16 +116,24 @@ func createTaskHandler(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get taskID: "+err.Error())
 	}
+	if err := insertstatementrevision(ctx, tx, taskID, nullsubtaskid, 1, req.Statement, admin.ID, now); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert statement revision: "+err.Error())
+	}
+	for _, cooldown := range req.Cooldowns {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO submission_cooldowns (task_id, max_submissions, window_minutes) VALUES (?, ?, ?)", taskID, cooldown.MaxSubmissions, cooldown.WindowMinutes); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert cooldown: "+err.Error())
+		}
+	}
 
 	for _, subtask := range req.Subtasks {
 		subtasktmp := Subtask{}
-		err = tx.GetContext(ctx, &subtasktmp, "SELECT * FROM subtasks WHERE task_id = ? AND name = ?", taskID, req.Name)
+		err = tx.GetContext(ctx, &subtasktmp, "SELECT * FROM subtasks WHERE task_id = ? AND name = ?", taskID, subtask.Name)
 		if err == nil {
 			return echo.NewHTTPError(http.StatusBadRequest, "subtask already exists")
 		} else if err != sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get subtask: "+err.Error())
 		}
-		if _, err := tx.ExecContext(ctx, "INSERT INTO subtasks (name, display_name, task_id, statement) VALUES (?, ?, ?, ?)", subtask.Name, subtask.DisplayName, taskID, subtask.Statement); err != nil {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO subtasks (name, display_name, task_id, statement, attempt_limit, checker, max_score) VALUES (?, ?, ?, ?, ?, ?, ?)", subtask.Name, subtask.DisplayName, taskID, subtask.Statement, subtask.AttemptLimit, subtask.Checker, subtask.MaxScore); err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert subtask: "+err.Error())
 		}
 		var subtaskID int
@@ -87,16 +141,116 @@ func createTaskHandler(c echo.Context) error {
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get subtaskID: "+err.Error())
 		}
+		if err := insertstatementrevision(ctx, tx, taskID, subtaskID, 1, subtask.Statement, admin.ID, now); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert statement revision: "+err.Error())
+		}
 		for _, answer := range subtask.Answers {
 			if _, err := tx.ExecContext(ctx, "INSERT INTO answers (task_id, subtask_id, answer, score) VALUES (?, ?, ?, ?)", taskID, subtaskID, answer.Answer, answer.Score); err != nil {
 				return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert answer: "+err.Error())
 			}
 		}
+		for _, hint := range subtask.Hints {
+			if _, err := inserthint(ctx, tx, taskID, subtaskID, hint); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert hint: "+err.Error())
+			}
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit transaction: "+err.Error())
 	}
+	standingsengine.invalidate()
 
 	return c.NoContent(http.StatusCreated)
 }
+
+type CheckAnswerRequest struct {
+	Answer  *string  `json:"answer,omitempty"`
+	Answers []string `json:"answers,omitempty"`
+	// 提出と同じく、指定した場合はその小課題だけで判定する
+	SubtaskName string `json:"subtask_name,omitempty"`
+}
+type CheckAnswerResult struct {
+	Answer             string               `json:"answer"`
+	IsScored           bool                 `json:"is_scored"`
+	Score              int                  `json:"score"`
+	SubtaskName        string               `json:"subtask_name,omitempty"`
+	SubTaskDisplayName string               `json:"subtask_display_name,omitempty"`
+	SubTaskMaxScore    int                  `json:"subtask_max_score,omitempty"`
+	Verdict            string               `json:"verdict"`
+	Message            string               `json:"message,omitempty"`
+	CheckerErrors      []CheckerErrorDetail `json:"checker_errors,omitempty"`
+}
+type CheckAnswerResponse struct {
+	Results []CheckAnswerResult `json:"results"`
+}
+
+// POST /api/admin/tasks/:taskname/check
+// 提出と同じ判定を行うが、提出や得点は記録しない
+func checkAnswerHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyAdminSession(c); err != nil {
+		return err
+	}
+
+	req := CheckAnswerRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+
+	answers := req.Answers
+	if req.Answer != nil {
+		answers = append([]string{*req.Answer}, answers...)
+	}
+	if len(answers) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "no answers")
+	}
+
+	task := Task{}
+	err := dbConn.GetContext(ctx, &task, "SELECT * FROM tasks WHERE name = ?", c.Param("taskname"))
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "task not found")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get task: "+err.Error())
+	}
+	targetSubtaskID, err := gettargetsubtaskid(ctx, dbConn, task, req.SubtaskName)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusBadRequest, "subtask not found")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get subtask: "+err.Error())
+	}
+
+	res := CheckAnswerResponse{
+		Results: []CheckAnswerResult{},
+	}
+	for _, answer := range answers {
+		result, err := judgeanswer(ctx, dbConn, task, targetSubtaskID, answer, time.Now())
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to judge answer: "+err.Error())
+		}
+		checkresult := CheckAnswerResult{
+			Answer:   answer,
+			IsScored: result.IsScored,
+			Score:    result.Score,
+			Verdict:  result.Verdict,
+			Message:  result.Message,
+		}
+		for _, checkererr := range result.CheckerErrors {
+			checkresult.CheckerErrors = append(checkresult.CheckerErrors, CheckerErrorDetail{
+				Checker: checkererr.Checker,
+				Reason:  checkererr.Reason,
+				Detail:  checkererr.Detail,
+			})
+		}
+		if result.IsScored {
+			checkresult.SubtaskName = result.Subtask.Name
+			checkresult.SubTaskDisplayName = result.Subtask.DisplayName
+			checkresult.SubTaskMaxScore = result.SubtaskMaxScore
+		}
+		res.Results = append(res.Results, checkresult)
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 小課題や問題で checker を指定しない場合に使う checker
+const defaultcheckername = "exact"
+
+type CheckResult struct {
+	IsScored bool   // 答えがこの小課題に当てはまるか
+	Score    int    // IsScored の場合の得点
+	Message  string // 提出者に返すメッセージ。なければ空
+}
+
+// 小課題に対する答えを判定する
+// DB への書き込みは行わない
+type Checker interface {
+	Check(ctx context.Context, q sqlx.QueryerContext, task Task, subtask Subtask, answer string) (CheckResult, error)
+}
+
+var checkers = map[string]Checker{}
+
+// checker を名前で登録する。init から呼ぶ
+func registerchecker(name string, checker Checker) {
+	if _, ok := checkers[name]; ok {
+		panic("checker already registered: " + name)
+	}
+	checkers[name] = checker
+}
+
+func getchecker(name string) (Checker, bool) {
+	if filename, ok := strings.CutPrefix(name, externalcheckerprefix); ok {
+		return externalchecker{filename: filename}, true
+	}
+	checker, ok := checkers[name]
+	return checker, ok
+}
+
+// 登録されている checker の名前の一覧。外部の checker は含まない
+func checkernames() []string {
+	names := []string{}
+	for name := range checkers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 小課題の判定に使う checker の名前。小課題、問題の順に指定があればそれを使う
+func subtaskcheckername(task Task, subtask Subtask) string {
+	if subtask.Checker != "" {
+		return subtask.Checker
+	}
+	if task.Checker != "" {
+		return task.Checker
+	}
+	return defaultcheckername
+}
+
+// answers テーブルの答えと完全に一致するかで判定する
+type exactchecker struct{}
+
+func (exactchecker) Check(ctx context.Context, q sqlx.QueryerContext, task Task, subtask Subtask, answer string) (CheckResult, error) {
+	answers := []Answer{}
+	if err := sqlx.SelectContext(ctx, q, &answers, "SELECT * FROM answers WHERE subtask_id = ?", subtask.ID); err != nil {
+		return CheckResult{}, err
+	}
+	for _, a := range answers {
+		if a.Answer == answer {
+			return CheckResult{IsScored: true, Score: a.Score}, nil
+		}
+	}
+	return CheckResult{}, nil
+}
+
+func init() {
+	registerchecker(defaultcheckername, exactchecker{})
+}
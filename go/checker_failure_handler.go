@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+type CheckerErrorDetail struct {
+	Checker string `json:"checker"`
+	Reason  string `json:"reason"`
+	Detail  string `json:"detail"`
+}
+
+// 提出の判定中に失敗した checker を記録する
+func insertcheckerfailures(ctx context.Context, tx *sqlx.Tx, submissionID int, taskID int, checkererrs []*CheckerError, at time.Time) error {
+	for _, checkererr := range checkererrs {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO checker_failures (submission_id, task_id, subtask_id, checker, reason, detail, occurred_at) VALUES (?, ?, ?, ?, ?, ?, ?)", submissionID, taskID, checkererr.SubtaskID, checkererr.Checker, checkererr.Reason, checkererr.Detail, at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type CheckerFailureDetail struct {
+	ID                 int       `json:"id" db:"id"`
+	SubmissionID       int       `json:"submission_id" db:"submission_id"`
+	TaskName           string    `json:"task_name" db:"task_name"`
+	SubTaskName        string    `json:"subtask_name" db:"subtask_name"`
+	UserName           string    `json:"user_name" db:"user_name"`
+	Answer             string    `json:"answer" db:"answer"`
+	Checker            string    `json:"checker" db:"checker"`
+	Reason             string    `json:"reason" db:"reason"`
+	Detail             string    `json:"detail" db:"detail"`
+	OccurredAtDatetime time.Time `json:"-" db:"occurred_at"`
+	OccurredAt         int64     `json:"occurred_at" db:"-"`
+	SubmissionVerdict  string    `json:"submission_verdict" db:"submission_verdict"` // 再判定で直ったかどうかの確認用
+}
+
+// GET /api/admin/checkerfailures
+// checker の実行の失敗を新しい順に返す
+func getCheckerFailuresHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyAdminSession(c); err != nil {
+		return err
+	}
+
+	limit := 100
+	if c.QueryParam("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(c.QueryParam("limit"))
+		if err != nil || limit <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be a positive integer")
+		}
+	}
+
+	query := "SELECT checker_failures.id, checker_failures.submission_id, checker_failures.checker, checker_failures.reason, checker_failures.detail, checker_failures.occurred_at," +
+		" tasks.name AS task_name, subtasks.name AS subtask_name, users.name AS user_name," +
+		" submissions.answer, submissions.verdict AS submission_verdict" +
+		" FROM checker_failures" +
+		" JOIN tasks ON tasks.id = checker_failures.task_id" +
+		" JOIN subtasks ON subtasks.id = checker_failures.subtask_id" +
+		" JOIN submissions ON submissions.id = checker_failures.submission_id" +
+		" JOIN users ON users.id = submissions.user_id"
+	params := []interface{}{}
+	if c.QueryParam("task_name") != "" {
+		query += " WHERE tasks.name = ?"
+		params = append(params, c.QueryParam("task_name"))
+	}
+	query += " ORDER BY checker_failures.id DESC LIMIT ?"
+	params = append(params, limit)
+
+	res := []CheckerFailureDetail{}
+	if err := dbConn.SelectContext(ctx, &res, query, params...); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get checker failures: "+err.Error())
+	}
+	for i := range res {
+		res[i].OccurredAt = res[i].OccurredAtDatetime.Unix()
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
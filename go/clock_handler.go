@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type ServerTimeResponse struct {
+	ServerTime   int64 `json:"server_time"`    // UNIX 時間 (秒)
+	ServerTimeMs int64 `json:"server_time_ms"` // UNIX 時間 (ミリ秒)
+}
+
+// GET /api/time
+// クライアントが時計を合わせるためにサーバーの時刻を返す
+func getServerTimeHandler(c echo.Context) error {
+	now := time.Now()
+	return c.JSON(http.StatusOK, ServerTimeResponse{
+		ServerTime:   now.Unix(),
+		ServerTimeMs: now.UnixMilli(),
+	})
+}
+
+type ClockSkewDetail struct {
+	UserName        string  `json:"user_name" db:"user_name"`
+	UserDisplayName string  `json:"user_display_name" db:"user_display_name"`
+	SubmissionCount int     `json:"submission_count" db:"submission_count"`
+	AvgSkew         float64 `json:"avg_skew" db:"avg_skew"` // サーバー時刻 - クライアント時刻 (秒)
+	MinSkew         int64   `json:"min_skew" db:"min_skew"`
+	MaxSkew         int64   `json:"max_skew" db:"max_skew"`
+}
+
+// GET /api/admin/clockskew
+// ユーザーごとに、提出時のサーバー時刻とクライアントが申告した時刻のずれを集計する
+func getClockSkewHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyAdminSession(c); err != nil {
+		return err
+	}
+
+	// min_skew を指定すると、ずれの絶対値の最大がそれ以上のユーザーのみを返す
+	minskew := int64(0)
+	if c.QueryParam("min_skew") != "" {
+		var err error
+		minskew, err = strconv.ParseInt(c.QueryParam("min_skew"), 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "failed to parse min_skew: "+err.Error())
+		}
+	}
+
+	res := []ClockSkewDetail{}
+	if err := dbConn.SelectContext(ctx, &res, "SELECT users.name AS user_name, users.display_name AS user_display_name, COUNT(*) AS submission_count,"+
+		" AVG(TIMESTAMPDIFF(SECOND, submissions.client_submitted_at, submissions.submitted_at)) AS avg_skew,"+
+		" MIN(TIMESTAMPDIFF(SECOND, submissions.client_submitted_at, submissions.submitted_at)) AS min_skew,"+
+		" MAX(TIMESTAMPDIFF(SECOND, submissions.client_submitted_at, submissions.submitted_at)) AS max_skew"+
+		" FROM submissions JOIN users ON users.id = submissions.user_id"+
+		" WHERE submissions.client_submitted_at IS NOT NULL"+
+		" GROUP BY users.id"+
+		" HAVING MAX(ABS(TIMESTAMPDIFF(SECOND, submissions.client_submitted_at, submissions.submitted_at))) >= ?"+
+		" ORDER BY MAX(ABS(TIMESTAMPDIFF(SECOND, submissions.client_submitted_at, submissions.submitted_at))) DESC, users.name", minskew); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get clock skew: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// コンテストの開始時刻。RISUCON_CONTEST_START に UNIX 時間 (秒) で指定する
+// 指定がない場合はゼロ値で、得点の減衰は行わない
+var conteststart = func() time.Time {
+	sec, err := strconv.ParseInt(getEnv("RISUCON_CONTEST_START", ""), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}()
+
+// コンテストの終了時刻。RISUCON_CONTEST_END に UNIX 時間 (秒) で指定する
+var contestend = func() time.Time {
+	sec, err := strconv.ParseInt(getEnv("RISUCON_CONTEST_END", ""), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}()
+
+// 終了前の何分間、順位表を凍結するか。0 の場合は凍結しない
+var freezeminutes = getEnvInt("RISUCON_FREEZE_MINUTES", 0)
+
+// 順位表を凍結する時刻。凍結しない場合はゼロ値
+func scoreboardfreezeat() time.Time {
+	if contestend.IsZero() || freezeminutes <= 0 {
+		return time.Time{}
+	}
+	return contestend.Add(-time.Duration(freezeminutes) * time.Minute)
+}
+
+// now の時点で順位表が凍結されているか。コンテストの終了後も、凍結を解除するまでは凍結時刻の順位表を見せる
+func isscoreboardfrozen(now time.Time) bool {
+	freezeat := scoreboardfreezeat()
+	return !freezeat.IsZero() && !now.Before(freezeat)
+}
+
+// 同点のチームの順位の付け方
+const (
+	tiebreaknone      = ""           // 同じ順位にする
+	tiebreaklastscore = "last_score" // 最後に得点が上がった時刻が早いほど上位にする
+)
+
+var (
+	tiebreak = getEnv("RISUCON_TIEBREAK", tiebreaknone)
+	// last_score の場合に、不正解の提出 1 回ごとに加える時間 (分)
+	// 得点した問題への、最後に得点が上がった時刻までの不正解だけを数える
+	tiebreakpenaltyminutes = getEnvInt("RISUCON_TIEBREAK_PENALTY_MINUTES", 0)
+)
+
+// 同点の順位付けの設定を確かめる。設定の誤りで黙って別の順位付けにならないよう、起動時に呼ぶ
+func checktiebreakconfig() error {
+	if tiebreak != tiebreaknone && tiebreak != tiebreaklastscore {
+		return fmt.Errorf("RISUCON_TIEBREAK must be empty or %q: %q", tiebreaklastscore, tiebreak)
+	}
+	if value := getEnv("RISUCON_TIEBREAK_PENALTY_MINUTES", ""); value != "" {
+		if minutes, err := strconv.Atoi(value); err != nil || minutes < 0 {
+			return fmt.Errorf("RISUCON_TIEBREAK_PENALTY_MINUTES must be a non-negative integer: %q", value)
+		}
+	}
+	return nil
+}
+
+// 得点の減衰の種類
+const (
+	decaynone   = ""
+	decaylinear = "linear" // interval_minutes ごとに percent % ずつ連続的に減らす
+	decaystep   = "step"   // interval_minutes が経過するたびに percent % ずつ減らす
+)
+
+// 問題ごとの得点の減衰。コンテスト開始からの経過時間で、答えの得点を floor_percent % まで減らす
+type DecayPolicy struct {
+	Type            string `json:"type"`
+	IntervalMinutes int    `json:"interval_minutes"`
+	Percent         int    `json:"percent"`
+	FloorPercent    int    `json:"floor_percent"`
+}
+
+func (task Task) decaypolicy() *DecayPolicy {
+	if task.DecayType == decaynone {
+		return nil
+	}
+	return &DecayPolicy{
+		Type:            task.DecayType,
+		IntervalMinutes: task.DecayIntervalMinutes,
+		Percent:         task.DecayPercent,
+		FloorPercent:    task.DecayFloorPercent,
+	}
+}
+
+// at に提出された答えの得点を、問題の減衰の設定に従って減らす
+func (task Task) decayedscore(score int, at time.Time) int {
+	if task.DecayType == decaynone || task.DecayIntervalMinutes <= 0 || conteststart.IsZero() || !at.After(conteststart) {
+		return score
+	}
+
+	intervals := at.Sub(conteststart).Minutes() / float64(task.DecayIntervalMinutes)
+	if task.DecayType == decaystep {
+		intervals = math.Floor(intervals)
+	}
+	percent := math.Max(100-float64(task.DecayPercent)*intervals, float64(task.DecayFloorPercent))
+	return int(math.Floor(float64(score) * percent / 100))
+}
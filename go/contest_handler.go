@@ -4,19 +4,38 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	// 小課題に紐づかないことを表す ID (問題文の改訂履歴や不正解の提出などで使う)
+	nullsubtaskid = -1
+
+	// 提出の判定結果
+	verdictaccepted = "accepted" // 小課題の満点
+	verdictpartial  = "partial"  // 部分点
+	verdictwrong    = "wrong"
+	verdictinvalid  = "invalidated"   // 管理者が無効にした提出。得点には数えない
+	verdictchecker  = "checker_error" // checker の実行に失敗した。再判定で直せる
+	verdictpending  = "pending"       // 非同期の判定を待っている
+	// 非同期の判定で、小課題ごとの提出回数の制限を超えていた。得点には数えない
+	verdictattemptlimit = "attempt_limit_exceeded"
+)
+
 var (
-	// Subtask は update がないのでキャッシュしておく
-	// メモ: initializeHandler でキャッシュを消すのを忘れずに
+	// Subtask は問題文の改訂以外で update がないのでキャッシュしておく
+	// メモ: initializeHandler と問題文の改訂時にキャッシュを消すのを忘れずに
 	subtaskcache = sync.Map{}
 )
 
@@ -26,13 +45,29 @@ type Task struct {
 	DisplayName     string `db:"display_name"`
 	Statement       string `db:"statement"`
 	SubmissionLimit int    `db:"submission_limit"`
+	// 同じチームの同じ答えの再提出を、提出回数に数えず前回の判定を返す
+	DedupeAnswers bool `db:"dedupe_answers"`
+	// 小課題で指定がない場合に使う checker。空の場合は defaultcheckername
+	Checker string `db:"checker"`
+	// 得点の減衰。DecayType が空の場合は減衰しない
+	DecayType            string `db:"decay_type"`
+	DecayIntervalMinutes int    `db:"decay_interval_minutes"`
+	DecayPercent         int    `db:"decay_percent"`
+	DecayFloorPercent    int    `db:"decay_floor_percent"`
+	// 動的配点。DynamicDecay が 0 の場合は answers の得点をそのまま使う
+	DynamicInitial int `db:"dynamic_initial"`
+	DynamicMinimum int `db:"dynamic_minimum"`
+	DynamicDecay   int `db:"dynamic_decay"`
 }
 type Subtask struct {
-	ID          int    `db:"id"`
-	Name        string `db:"name"`
-	DisplayName string `db:"display_name"`
-	TaskID      int    `db:"task_id"`
-	Statement   string `db:"statement"`
+	ID           int    `db:"id"`
+	Name         string `db:"name"`
+	DisplayName  string `db:"display_name"`
+	TaskID       int    `db:"task_id"`
+	Statement    string `db:"statement"`
+	AttemptLimit int    `db:"attempt_limit"` // 0 の場合は制限なし
+	Checker      string `db:"checker"`       // 空の場合は問題の checker を使う
+	MaxScore     int    `db:"max_score"`     // checker で判定する場合の満点
 }
 type Answer struct {
 	ID        int    `db:"id"`
@@ -47,6 +82,13 @@ type Submission struct {
 	UserID      int       `db:"user_id"`
 	SubmittedAt time.Time `db:"submitted_at"`
 	Answer      string    `db:"answer"`
+	// 提出時に指定した小課題。なければ nullsubtaskid
+	TargetSubtaskID int    `db:"target_subtask_id"`
+	SubtaskID       int    `db:"subtask_id"` // 判定時に一致した小課題。なければ nullsubtaskid
+	Score           int    `db:"score"`      // 判定時に与えた得点
+	Verdict         string `db:"verdict"`
+	// クライアントが申告した提出時刻。submitted_at はサーバーの時刻を使う
+	ClientSubmittedAt sql.NullTime `db:"client_submitted_at"`
 }
 
 type TaskAbstract struct {
@@ -56,6 +98,13 @@ type TaskAbstract struct {
 	Score           int    `json:"score,omitempty"`
 	SubmissionLimit int    `json:"submission_limit,omitempty"`
 	SubmissionCount int    `json:"submission_count,omitempty"`
+	// 順位表で detail=subtasks の場合だけ返す
+	Subtasks []SubtaskAbstract `json:"subtasks,omitempty"`
+}
+type SubtaskAbstract struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	MaxScore    int    `json:"max_score"`
 }
 
 func gettaskabstarcts(ctx context.Context, c echo.Context) ([]TaskAbstract, error) {
@@ -149,6 +198,35 @@ func gettaskabstarcts(ctx context.Context, c echo.Context) ([]TaskAbstract, erro
 		}
 		subtask_maxscores[answer.SubtaskID] = max(subtask_maxscores[answer.SubtaskID], answer.Score)
 	}
+	// checker で判定する小課題は、小課題に設定された満点を使う
+	for _, subtask := range subtasks {
+		subtask_maxscores[subtask.ID] = max(subtask_maxscores[subtask.ID], subtask.MaxScore)
+	}
+
+	// 動的配点の小課題は、得点と満点を現在の配点に換算する
+	dynamicvalues, err := getdynamicvalues(ctx, dbConn, tasks, subtasks)
+	if err != nil {
+		return []TaskAbstract{}, err
+	}
+	scaledynamicscores(scores, subtask_maxscores, dynamicvalues)
+	for subtaskID, value := range dynamicvalues {
+		subtask_maxscores[subtaskID] = value
+	}
+	// 凍結中は、満点には凍結時刻の時点の配点を見せる。チームの得点は現在の配点のままにする
+	if verifyAdminSession(c) != nil {
+		publicmaxscores, err := standingsengine.publicmaxscores(ctx, time.Now())
+		if err != nil {
+			return []TaskAbstract{}, err
+		}
+		for subtaskID, value := range publicmaxscores {
+			subtask_maxscores[subtaskID] = value
+		}
+	}
+
+	hintcosts, _, err := gethintcosts(ctx, dbConn)
+	if err != nil {
+		return []TaskAbstract{}, err
+	}
 
 	if err := verifyUserSession(c); err == nil {
 		sess, _ := session.Get(defaultSessionIDKey, c)
@@ -202,6 +280,7 @@ func gettaskabstarcts(ctx context.Context, c echo.Context) ([]TaskAbstract, erro
 
 					score += score_for_subtask
 				}
+				score = max(score-hintcosts[team.ID][task.ID], 0)
 			} else if err != sql.ErrNoRows {
 				return []TaskAbstract{}, err
 			}
@@ -236,6 +315,14 @@ type TeamsStandingsSub struct {
 	TaskName     string `json:"task_name"`
 	HasSubmitted bool   `json:"has_submitted"`
 	Score        int    `json:"score"`
+	FirstSolve   bool   `json:"first_solve"` // 小課題のいずれかを最初に解いた
+	// detail=subtasks の場合だけ返す
+	Subtasks []SubtaskStandings `json:"subtasks,omitempty"`
+}
+type SubtaskStandings struct {
+	SubtaskName  string `json:"subtask_name"`
+	Score        int    `json:"score"`
+	AttemptCount int    `json:"attempt_count"` // getsubtaskattemptcount と同じく数えた提出回数
 }
 type TeamsStandings struct {
 	Rank               int                 `json:"rank"`
@@ -249,228 +336,58 @@ type TeamsStandings struct {
 	Member2DisplayName string              `json:"member2_display_name,omitempty"`
 	ScoringData        []TeamsStandingsSub `json:"scoring_data"`
 	TotalScore         int                 `json:"total_score"`
+	HintsUsed          int                 `json:"hints_used"`
+	Tiebreak           *Tiebreak           `json:"tiebreak,omitempty"`
 }
 type Standings struct {
 	TasksData     []TaskAbstract   `json:"tasks_data"`
 	StandingsData []TeamsStandings `json:"standings_data"`
 }
 
-func getstandings(ctx context.Context) (Standings, error) {
-	standings := Standings{}
-
-	tasks := []Task{}
-	if err := dbConn.SelectContext(ctx, &tasks, "SELECT * FROM tasks ORDER BY name"); err != nil {
-		return Standings{}, err
-	}
-
-	var all_answers []Answer
-	var subtask_maxscores map[int]int
-
-	if err := dbConn.SelectContext(ctx, &all_answers, "SELECT * FROM answers"); err != nil {
-		return Standings{}, err
-	}
-
-	subtask_maxscores = map[int]int{}
-
-	var subtasks []Subtask
-
-	if err := dbConn.SelectContext(ctx, &subtasks, "SELECT * FROM subtasks"); err != nil {
-		return Standings{}, err
-	}
-
-	var subtask_per_task = map[int]([]Subtask){}
-
-	for _, subtask := range subtasks {
-		if _, ok := subtask_per_task[subtask.TaskID]; !ok {
-			subtask_per_task[subtask.TaskID] = []Subtask{}
-		}
-		subtask_per_task[subtask.TaskID] = append(subtask_per_task[subtask.TaskID], subtask)
-	}
-
-	for _, answer := range all_answers {
-		if _, ok := subtask_maxscores[answer.SubtaskID]; !ok {
-			subtask_maxscores[answer.SubtaskID] = 0
-		}
-		subtask_maxscores[answer.SubtaskID] = max(subtask_maxscores[answer.SubtaskID], answer.Score)
-	}
-
-	for _, task := range tasks {
-		subtasks := subtask_per_task[task.ID]
-		maxscore := 0
-		for _, subtask := range subtasks {
-			subtaskmaxscore := subtask_maxscores[subtask.ID]
-			maxscore += subtaskmaxscore
-		}
-		standings.TasksData = append(standings.TasksData, TaskAbstract{
-			Name:        task.Name,
-			DisplayName: task.DisplayName,
-			MaxScore:    maxscore,
-		})
-	}
-
-	type Res struct {
-		UserID    int `db:"user_id"`
-		SubtaskID int `db:"subtask_id"`
-		Score     int `db:"score"`
-	}
-
-	scores := map[int](map[int]int){}
-
-	var subtask_scores []Res
-
-	if err := dbConn.SelectContext(ctx, &subtask_scores, "SELECT user_id, subtask_id, score FROM subtask_scores_of_user"); err != nil && err != sql.ErrNoRows {
-		return Standings{}, err
-	}
-
-	for _, subtask_score := range subtask_scores {
-		if _, ok := scores[subtask_score.UserID]; !ok {
-			scores[subtask_score.UserID] = map[int]int{}
-		}
-		scores[subtask_score.UserID][subtask_score.SubtaskID] = subtask_score.Score
-	}
-
-	type Submit struct {
-		TaskID int `db:"task_id"`
-		UserID int `db:"user_id"`
-	}
-
-	sub_cnts := map[int](map[int]int){}
-
-	var all_subs []Submit
-
-	if err := dbConn.SelectContext(ctx, &all_subs, "SELECT task_id, user_id FROM submissions"); err != nil && err != sql.ErrNoRows {
-		return Standings{}, err
-	}
-
-	for _, s := range all_subs {
-		if _, ok := sub_cnts[s.TaskID]; !ok {
-			sub_cnts[s.TaskID] = map[int]int{}
-		}
-		sub_cnts[s.TaskID][s.UserID]++
-	}
-
-	var user_result []User
-
-	if err := dbConn.SelectContext(ctx, &user_result, "SELECT * FROM users"); err != nil {
-		return Standings{}, err
-	}
-
-	var users_map = map[int]User{}
+// GET /api/stanings
+func getStandingsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 
-	for _, user := range user_result {
-		users_map[user.ID] = user
+	// detail=subtasks を指定すると、小課題ごとの得点と提出回数も返す
+	detail := c.QueryParam("detail")
+	if detail != "" && detail != "subtasks" {
+		return echo.NewHTTPError(http.StatusBadRequest, "detail must be subtasks")
 	}
 
-	teams := []Team{}
-	if err := dbConn.SelectContext(ctx, &teams, "SELECT * FROM teams ORDER BY name"); err != nil {
-		return Standings{}, err
+	// at を指定すると、その時点の順位表を返す
+	at, ok, err := parseunixparam(c, "at")
+	if err != nil {
+		return err
 	}
-	for _, team := range teams {
-		teamstandings := TeamsStandings{}
-		teamstandings.TeamName = team.Name
-		teamstandings.TeamDisplayName = team.DisplayName
-		teamstandings.TotalScore = 0
-
-		leader := users_map[team.LeaderID]
-		teamstandings.LeaderName = leader.Name
-		teamstandings.LeaderDisplayName = leader.DisplayName
-		if team.Member1ID != nulluserid {
-			member1 := users_map[team.Member1ID]
-			teamstandings.Member1Name = member1.Name
-			teamstandings.Member1DisplayName = member1.DisplayName
-		}
-		if team.Member2ID != nulluserid {
-			member2 := users_map[team.Member2ID]
-			teamstandings.Member2Name = member2.Name
-			teamstandings.Member2DisplayName = member2.DisplayName
-		}
-
-		scoringdata := []TeamsStandingsSub{}
-		for _, task := range tasks {
-			taskscoringdata := TeamsStandingsSub{}
-			taskscoringdata.TaskName = task.Name
-			taskscoringdata.HasSubmitted = false
-			taskscoringdata.Score = 0
-
-			subtasks := subtask_per_task[task.ID]
-
-			tm, ok := sub_cnts[task.ID]
-			if !ok {
-				tm = map[int]int{}
-			}
-			submissioncount := tm[team.LeaderID]
-			if submissioncount > 0 {
-				taskscoringdata.HasSubmitted = true
-			}
-			if team.Member1ID != nulluserid {
-				cnt := tm[team.Member1ID]
-				if cnt > 0 {
-					taskscoringdata.HasSubmitted = true
-				}
-			}
-			if team.Member2ID != nulluserid {
-				cnt := tm[team.Member2ID]
-				if cnt > 0 {
-					taskscoringdata.HasSubmitted = true
-				}
+	if ok {
+		// 凍結中は凍結時刻より後の順位表は見せない
+		if verifyAdminSession(c) != nil {
+			until, err := standingsengine.publicuntil(ctx, time.Now())
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get standings: "+err.Error())
 			}
-
-			for _, subtask := range subtasks {
-				subtaskscore := 0
-
-				leaderscore := scores[team.LeaderID][subtask.ID]
-				if subtaskscore < leaderscore {
-					subtaskscore = leaderscore
-				}
-
-				if team.Member1ID != nulluserid {
-					member1score := scores[team.Member1ID][subtask.ID]
-					if subtaskscore < member1score {
-						subtaskscore = member1score
-					}
-				}
-				if team.Member2ID != nulluserid {
-					member2score := scores[team.Member2ID][subtask.ID]
-					if subtaskscore < member2score {
-						subtaskscore = member2score
-					}
-				}
-				taskscoringdata.Score += subtaskscore
+			if !until.IsZero() && !at.Before(until) {
+				at = until.Add(-time.Second)
 			}
-			scoringdata = append(scoringdata, taskscoringdata)
-			teamstandings.TotalScore += taskscoringdata.Score
 		}
-		teamstandings.ScoringData = scoringdata
-		standings.StandingsData = append(standings.StandingsData, teamstandings)
-	}
-
-	// sort
-	for i := 0; i < len(standings.StandingsData); i++ {
-		for j := i + 1; j < len(standings.StandingsData); j++ {
-			if (standings.StandingsData[i].TotalScore < standings.StandingsData[j].TotalScore) || (standings.StandingsData[i].TotalScore == standings.StandingsData[j].TotalScore && standings.StandingsData[i].TeamName > standings.StandingsData[j].TeamName) {
-				tmp := standings.StandingsData[i]
-				standings.StandingsData[i] = standings.StandingsData[j]
-				standings.StandingsData[j] = tmp
-			}
+		// at の時刻の提出も含める
+		standings, err := replaycache.standingsat(ctx, at.Add(time.Second))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get standings: "+err.Error())
 		}
-	}
-	for i := 0; i < len(standings.StandingsData); i++ {
-		standings.StandingsData[i].Rank = 1
-		for j := 0; j < len(standings.StandingsData); j++ {
-			if standings.StandingsData[i].TotalScore < standings.StandingsData[j].TotalScore {
-				standings.StandingsData[i].Rank++
-			}
+		if detail == "" {
+			standings = compactstandings(standings)
 		}
+		return c.JSON(http.StatusOK, standings)
 	}
 
-	return standings, nil
-}
-
-// GET /api/stanings
-func getStandingsHandler(c echo.Context) error {
-	ctx := c.Request().Context()
-
-	standings, err := getstandings(ctx)
+	// admin には凍結中も現在の順位表を見せる
+	var standings *Standings
+	if verifyAdminSession(c) == nil {
+		standings, err = standingsengine.get(ctx, detail == "subtasks")
+	} else {
+		standings, err = standingsengine.getpublic(ctx, time.Now(), detail == "subtasks")
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get standings: "+err.Error())
 	}
@@ -479,12 +396,18 @@ func getStandingsHandler(c echo.Context) error {
 }
 
 type SubtaskDetail struct {
-	ID          int    `json:"id"`
-	Name        string `json:"name"`
-	DisplayName string `json:"display_name"`
-	Statement   string `json:"statement"`
-	MaxScore    int    `json:"max_score"`
-	Score       int    `json:"score"`
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	DisplayName  string `json:"display_name"`
+	Statement    string `json:"statement"`
+	MaxScore     int    `json:"max_score"`
+	Score        int    `json:"score"`
+	AttemptLimit int    `json:"attempt_limit,omitempty"`
+	AttemptCount int    `json:"attempt_count,omitempty"`
+	// 最初に解いたチーム。凍結中は凍結時刻より前に解いたもの、または自分のチームに限る
+	FirstSolveTeamName        string `json:"first_solve_team_name,omitempty"`
+	FirstSolveTeamDisplayName string `json:"first_solve_team_display_name,omitempty"`
+	IsFirstSolve              bool   `json:"is_first_solve,omitempty"` // 自分のチームが最初に解いた
 }
 type TaskDetail struct {
 	Name            string          `json:"name"`
@@ -494,7 +417,14 @@ type TaskDetail struct {
 	Score           int             `json:"score"`
 	SubmissionLimit int             `json:"submission_limit"`
 	SubmissionCount int             `json:"submission_count"`
+	DedupeAnswers   bool            `json:"dedupe_answers"`
+	Decay           *DecayPolicy    `json:"decay,omitempty"`   // 得点が減衰する問題のみ
+	Dynamic         *DynamicScoring `json:"dynamic,omitempty"` // 動的配点の問題のみ
 	Subtasks        []SubtaskDetail `json:"subtasks"`
+	HintCost        int             `json:"hint_cost"`
+	Cooldown        *CooldownState  `json:"cooldown,omitempty"` // 提出間隔の制限がある場合のみ
+	Revision        int             `json:"revision"`
+	RevisedAt       int64           `json:"revised_at"`
 }
 
 // GET /api/tasks/:taskname
@@ -536,27 +466,84 @@ func getTaskHandler(c echo.Context) error {
 		DisplayName:     task.DisplayName,
 		Statement:       task.Statement,
 		SubmissionLimit: task.SubmissionLimit,
+		DedupeAnswers:   task.DedupeAnswers,
+		Decay:           task.decaypolicy(),
+		Dynamic:         task.dynamicscoring(),
 		MaxScore:        0,
 		Score:           0,
 		Subtasks:        []SubtaskDetail{},
 		SubmissionCount: 0,
 	}
 
+	dynamicvalues, err := getdynamicvalues(c.Request().Context(), tx, []Task{task}, subtasks)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get dynamic values: "+err.Error())
+	}
+	basemaxscores := map[int]int{}
+
 	for _, subtask := range subtasks {
 		subtaskdetail := SubtaskDetail{
-			ID:          subtask.ID,
-			Name:        subtask.Name,
-			DisplayName: subtask.DisplayName,
-			Statement:   subtask.Statement,
-			Score:       0,
+			ID:           subtask.ID,
+			Name:         subtask.Name,
+			DisplayName:  subtask.DisplayName,
+			Statement:    subtask.Statement,
+			Score:        0,
+			AttemptLimit: subtask.AttemptLimit,
 		}
-		if err := tx.GetContext(c.Request().Context(), &subtaskdetail.MaxScore, "SELECT MAX(score) FROM answers WHERE subtask_id = ?", subtask.ID); err != nil {
+		subtaskdetail.MaxScore, err = getsubtaskmaxscore(c.Request().Context(), tx, subtask)
+		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get subtask score: "+err.Error())
 		}
+		basemaxscores[subtask.ID] = subtaskdetail.MaxScore
+		if value, ok := dynamicvalues[subtask.ID]; ok {
+			subtaskdetail.MaxScore = value
+		}
 		res.Subtasks = append(res.Subtasks, subtaskdetail)
 		res.MaxScore += subtaskdetail.MaxScore
 	}
 
+	firstsolves, err := gettaskfirstsolves(c.Request().Context(), tx, task.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get first solves: "+err.Error())
+	}
+	firstsolveuntil := time.Time{}
+	if verifyAdminSession(c) != nil {
+		firstsolveuntil, err = standingsengine.publicuntil(c.Request().Context(), time.Now())
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get standings: "+err.Error())
+		}
+
+		// 凍結中は、満点には凍結時刻の時点の配点を見せる。チームの得点は現在の配点のままにする
+		publicmaxscores, err := standingsengine.publicmaxscores(c.Request().Context(), time.Now())
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get standings: "+err.Error())
+		}
+		if publicmaxscores != nil {
+			res.MaxScore = 0
+			for i, subtask := range res.Subtasks {
+				if value, ok := publicmaxscores[subtask.ID]; ok {
+					res.Subtasks[i].MaxScore = value
+				}
+				res.MaxScore += res.Subtasks[i].MaxScore
+			}
+		}
+	}
+	for i, subtask := range res.Subtasks {
+		if firstsolve, ok := firstsolves[subtask.ID]; ok && (firstsolveuntil.IsZero() || firstsolve.SolvedAt.Before(firstsolveuntil)) {
+			res.Subtasks[i].FirstSolveTeamName = firstsolve.TeamName
+			res.Subtasks[i].FirstSolveTeamDisplayName = firstsolve.TeamDisplayName
+		}
+	}
+
+	revision, revisedat, err := getcurrentrevision(c.Request().Context(), tx, task.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get revision: "+err.Error())
+	}
+	res.Revision = revision
+	if revision > 0 {
+		res.RevisedAt = revisedat.Unix()
+	}
+
 	if err := verifyUserSession(c); err == nil {
 		sess, _ := session.Get(defaultSessionIDKey, c)
 		username, _ := sess.Values[defaultSessionUserNameKey].(string)
@@ -628,8 +615,48 @@ func getTaskHandler(c echo.Context) error {
 				}
 			}
 
-			for _, subtask := range res.Subtasks {
-				res.Score += subtask.Score
+			for i, subtask := range res.Subtasks {
+				if value, ok := dynamicvalues[subtask.ID]; ok {
+					res.Subtasks[i].Score = scaledynamicscore(subtask.Score, basemaxscores[subtask.ID], value)
+				}
+				res.Score += res.Subtasks[i].Score
+			}
+
+			res.HintCost, err = gettaskhintcost(c.Request().Context(), tx, team.ID, task.ID)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get hint cost: "+err.Error())
+			}
+			// ヒントのコストを引いても、問題の得点は 0 未満にはしない
+			res.Score = max(res.Score-res.HintCost, 0)
+
+			cooldowns, err := getcooldowns(c.Request().Context(), tx, task.ID)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get cooldowns: "+err.Error())
+			}
+			if len(cooldowns) > 0 {
+				cooldownstate, err := getcooldownstate(c.Request().Context(), tx, cooldowns, task.ID, team, time.Now())
+				if err != nil {
+					return echo.NewHTTPError(http.StatusInternalServerError, "failed to get cooldown state: "+err.Error())
+				}
+				res.Cooldown = &cooldownstate
+			}
+
+			for i, subtask := range res.Subtasks {
+				if firstsolve, ok := firstsolves[subtask.ID]; ok && firstsolve.TeamID == team.ID {
+					res.Subtasks[i].IsFirstSolve = true
+					res.Subtasks[i].FirstSolveTeamName = team.Name
+					res.Subtasks[i].FirstSolveTeamDisplayName = team.DisplayName
+				}
+			}
+
+			for i, subtask := range subtasks {
+				if subtask.AttemptLimit == 0 {
+					continue
+				}
+				res.Subtasks[i].AttemptCount, err = getsubtaskattemptcount(c.Request().Context(), tx, subtask, team, 0)
+				if err != nil {
+					return echo.NewHTTPError(http.StatusInternalServerError, "failed to get attempt count: "+err.Error())
+				}
 			}
 		} else if err != sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get team: "+err.Error())
@@ -643,19 +670,176 @@ func getTaskHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, res)
 }
 
+// MySQL の DATETIME に記録できる時刻の範囲
+var (
+	mindatetime = time.Date(1000, 1, 1, 0, 0, 0, 0, time.UTC)
+	maxdatetime = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+)
+
 type SubmitRequest struct {
-	TaskName  string `json:"task_name"`
-	Answer    string `json:"answer"`
-	Timestamp int64  `json:"timestamp"`
+	TaskName string `json:"task_name"`
+	// 答えを判定する小課題。提出回数の制限がある小課題で得点するには指定する
+	SubtaskName string `json:"subtask_name,omitempty"`
+	Answer      string `json:"answer"`
+	Timestamp   int64  `json:"timestamp"` // クライアントの時刻 (UNIX 時間)。記録のみで、提出時刻には使わない
 }
 
 type SubmitResponse struct {
+	SubmissionID         int    `json:"submission_id"`
 	IsScored             bool   `json:"is_scored"`
 	Score                int    `json:"score"`
+	BaseScore            int    `json:"base_score,omitempty"` // 得点が減衰する問題で、減衰させる前の得点
 	SubtaskName          string `json:"subtask_name,omitempty"`
 	SubTaskDisplayName   string `json:"subtask_display_name,omitempty"`
 	SubTaskMaxScore      int    `json:"subtask_max_score,omitempty"`
+	Verdict              string `json:"verdict"`
+	Message              string `json:"message,omitempty"` // checker が返したメッセージ
 	RemainingSubmissions int    `json:"remaining_submissions"`
+	NextSubmissionAt     int64  `json:"next_submission_at"`
+	IsDuplicate          bool   `json:"is_duplicate"`   // 以前と同じ答えのため、記録せずに前回の判定を返した
+	IsFirstSolve         bool   `json:"is_first_solve"` // この提出で、小課題を最初に満点で解いた
+}
+
+type CooldownErrorResponse struct {
+	Message          string `json:"message"`
+	NextSubmissionAt int64  `json:"next_submission_at"`
+}
+
+type JudgeResult struct {
+	IsScored        bool
+	Score           int // 減衰させた後の得点
+	BaseScore       int // 減衰させる前の得点。判定結果はこちらで決める
+	Subtask         Subtask
+	SubtaskMaxScore int
+	Verdict         string
+	Message         string // checker が返したメッセージ
+	// 実行に失敗した checker。他の小課題で得点した場合も記録する
+	CheckerErrors []*CheckerError
+}
+
+// 小課題の満点。answers テーブルの最高得点と、checker 用に小課題に設定された満点の大きいほう
+func getsubtaskmaxscore(ctx context.Context, q sqlx.QueryerContext, subtask Subtask) (int, error) {
+	answermaxscore := 0
+	if err := sqlx.GetContext(ctx, q, &answermaxscore, "SELECT COALESCE(MAX(score), 0) FROM answers WHERE subtask_id = ?", subtask.ID); err != nil {
+		return 0, err
+	}
+	return max(answermaxscore, subtask.MaxScore), nil
+}
+
+// at に提出された答えを判定する。DB への書き込みは行わない
+// 複数の小課題に当てはまる場合は、得点の最も高い小課題の結果を返す
+// targetSubtaskID を指定した場合はその小課題だけで判定し、指定しない場合は提出回数の制限がない小課題だけで判定する
+func judgeanswer(ctx context.Context, q sqlx.QueryerContext, task Task, targetSubtaskID int, answer string, at time.Time) (JudgeResult, error) {
+	result := JudgeResult{
+		Verdict: verdictwrong,
+	}
+
+	subtasks := []Subtask{}
+	if err := sqlx.SelectContext(ctx, q, &subtasks, "SELECT * FROM subtasks WHERE task_id = ? ORDER BY id", task.ID); err != nil {
+		return JudgeResult{}, err
+	}
+	for _, subtask := range subtasks {
+		if !isjudgedsubtask(subtask, targetSubtaskID) {
+			continue
+		}
+		checkername := subtaskcheckername(task, subtask)
+		checker, ok := getchecker(checkername)
+		if !ok {
+			return JudgeResult{}, fmt.Errorf("checker %q of subtask %q is not registered", checkername, subtask.Name)
+		}
+		checkresult, err := checker.Check(ctx, q, task, subtask, answer)
+		var checkererr *CheckerError
+		if errors.As(err, &checkererr) {
+			checkererr.SubtaskID = subtask.ID
+			result.CheckerErrors = append(result.CheckerErrors, checkererr)
+			continue
+		} else if err != nil {
+			return JudgeResult{}, err
+		}
+		if !checkresult.IsScored {
+			continue
+		}
+
+		subtaskmaxscore, err := getsubtaskmaxscore(ctx, q, subtask)
+		if err != nil {
+			return JudgeResult{}, err
+		}
+		// 外部の checker と同じく、満点の範囲外の得点は checker の失敗とする
+		if checkresult.Score < 0 || checkresult.Score > subtaskmaxscore {
+			result.CheckerErrors = append(result.CheckerErrors, &CheckerError{
+				Checker:   checkername,
+				SubtaskID: subtask.ID,
+				Reason:    "invalid_score",
+				Detail:    fmt.Sprintf("score %d is out of range [0, %d]", checkresult.Score, subtaskmaxscore),
+			})
+			continue
+		}
+		if result.IsScored && checkresult.Score <= result.BaseScore {
+			continue
+		}
+		result.IsScored = true
+		result.BaseScore = checkresult.Score
+		result.Score = task.decayedscore(checkresult.Score, at)
+		result.Subtask = subtask
+		result.SubtaskMaxScore = subtaskmaxscore
+		result.Message = checkresult.Message
+		if checkresult.Score >= subtaskmaxscore {
+			result.Verdict = verdictaccepted
+		} else {
+			result.Verdict = verdictpartial
+		}
+	}
+	// どの小課題でも得点せず、判定できなかった小課題がある場合は不正解にしない
+	if !result.IsScored && len(result.CheckerErrors) > 0 {
+		result.Verdict = verdictchecker
+		result.Message = "failed to run the checker. this submission will be judged again when an admin rejudges it"
+	}
+
+	return result, nil
+}
+
+// チームのメンバーが同じ問題の同じ小課題に同じ答えを提出していれば、その最新の提出を返す
+func getduplicatesubmission(ctx context.Context, q sqlx.QueryerContext, task Task, team Team, targetSubtaskID int, answer string) (Submission, bool, error) {
+	query, params, err := sqlx.In("SELECT * FROM submissions WHERE task_id = ? AND user_id IN (?) AND target_subtask_id = ? AND answer = ? AND verdict != ? ORDER BY submitted_at DESC, id DESC LIMIT 1", task.ID, teammemberids(team), targetSubtaskID, answer, verdictinvalid)
+	if err != nil {
+		return Submission{}, false, err
+	}
+	submission := Submission{}
+	err = sqlx.GetContext(ctx, q, &submission, query, params...)
+	if err == sql.ErrNoRows {
+		return Submission{}, false, nil
+	} else if err != nil {
+		return Submission{}, false, err
+	}
+	return submission, true, nil
+}
+
+// 以前の提出の判定結果から SubmitResponse を作る
+func duplicatesubmitresponse(ctx context.Context, q sqlx.QueryerContext, previous Submission) (SubmitResponse, error) {
+	res := SubmitResponse{
+		SubmissionID: previous.ID,
+		IsScored:     false,
+		Score:        previous.Score,
+		Verdict:      previous.Verdict,
+		IsDuplicate:  true,
+	}
+	if previous.SubtaskID == nullsubtaskid {
+		return res, nil
+	}
+
+	subtask := Subtask{}
+	if err := sqlx.GetContext(ctx, q, &subtask, "SELECT * FROM subtasks WHERE id = ?", previous.SubtaskID); err != nil {
+		return SubmitResponse{}, err
+	}
+	subtaskmaxscore, err := getsubtaskmaxscore(ctx, q, subtask)
+	if err != nil {
+		return SubmitResponse{}, err
+	}
+	res.IsScored = true
+	res.SubtaskName = subtask.Name
+	res.SubTaskDisplayName = subtask.DisplayName
+	res.SubTaskMaxScore = subtaskmaxscore
+	return res, nil
 }
 
 // POST /api/submit
@@ -670,7 +854,8 @@ func submitHandler(c echo.Context) error {
 	sess, _ := session.Get(defaultSessionIDKey, c)
 	username, _ := sess.Values[defaultSessionUserNameKey].(string)
 
-	tx, err := dbConn.BeginTxx(c.Request().Context(), nil)
+	// チームの行のロックを待った後の読み取りで、先に記録された提出が見えるようにする
+	tx, err := dbConn.BeginTxx(c.Request().Context(), &sql.TxOptions{Isolation: sql.LevelReadCommitted})
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
 	}
@@ -694,6 +879,47 @@ func submitHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
 
+	// 提出時刻はサーバーの時刻を使い、クライアントの時刻はずれの集計用に別に記録する
+	// DATETIME に入らない時刻は記録できないので、判定する前に断る
+	clienttimestamp := sql.NullTime{}
+	if req.Timestamp != 0 {
+		clienttime := time.Unix(req.Timestamp, 0)
+		if clienttime.Before(mindatetime) || clienttime.After(maxdatetime) {
+			return echo.NewHTTPError(http.StatusBadRequest, "timestamp is out of range")
+		}
+		clienttimestamp = sql.NullTime{Time: clienttime, Valid: true}
+	}
+
+	now := time.Now()
+
+	// Idempotency-Key が指定された場合、同じキーでの再送には最初のレスポンスを返す
+	idempotencykey := c.Request().Header.Get(idempotencykeyheader)
+	if utf8.RuneCountInString(idempotencykey) > maxnamelength {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be at most %d characters", idempotencykeyheader, maxnamelength))
+	}
+	if idempotencykey != "" {
+		// 再送時にクライアントの時刻は変わりうるので、問題と答えのみを比較する
+		idemrequest := req.TaskName + "\n" + req.SubtaskName + "\n" + req.Answer
+		claimed, err := claimidempotencykey(ctx, tx, user.ID, idempotencykey, idemrequest, now)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to claim idempotency key: "+err.Error())
+		}
+		if !claimed {
+			tx.Rollback()
+			idem, err := getidempotentresponse(ctx, dbConn, user.ID, idempotencykey, idemrequest)
+			if err == errIdempotencyKeyReused {
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+			} else if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get idempotency key: "+err.Error())
+			}
+			if !idem.Response.Valid {
+				return echo.NewHTTPError(http.StatusConflict, "a request with the same idempotency key is in progress")
+			}
+			c.Response().Header().Set("Idempotent-Replayed", "true")
+			return c.JSONBlob(idem.Status, []byte(idem.Response.String))
+		}
+	}
+
 	task := Task{}
 	err = tx.GetContext(c.Request().Context(), &task, "SELECT * FROM tasks WHERE name = ?", req.TaskName)
 	if err == sql.ErrNoRows {
@@ -702,6 +928,19 @@ func submitHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get task: "+err.Error())
 	}
 
+	targetSubtaskID, err := gettargetsubtaskid(ctx, tx, task, req.SubtaskName)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusBadRequest, "subtask not found")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get subtask: "+err.Error())
+	}
+
+	// 提出回数や提出間隔の制限を同じチームの同時の提出で破られないよう、数える前にチームの行をロックする
+	lockedteamID := 0
+	if err := tx.GetContext(ctx, &lockedteamID, "SELECT id FROM teams WHERE id = ? FOR UPDATE", team.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to lock team: "+err.Error())
+	}
+
 	submissionscount := 0
 	if err := tx.GetContext(c.Request().Context(), &submissionscount, "SELECT COUNT(*) FROM submissions WHERE task_id = ? AND user_id = ?", task.ID, team.LeaderID); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get submissions count: "+err.Error())
@@ -723,52 +962,146 @@ func submitHandler(c echo.Context) error {
 		submissionscount += cnt
 	}
 
+	// 同じ答えをすでに提出している場合は、記録せずに前回の判定を返す
+	if task.DedupeAnswers {
+		previous, found, err := getduplicatesubmission(ctx, tx, task, team, targetSubtaskID, req.Answer)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get previous submission: "+err.Error())
+		}
+		if found {
+			res, err := duplicatesubmitresponse(ctx, tx, previous)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get previous verdict: "+err.Error())
+			}
+			res.RemainingSubmissions = task.SubmissionLimit - submissionscount
+
+			cooldowns, err := getcooldowns(ctx, tx, task.ID)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get cooldowns: "+err.Error())
+			}
+			cooldownstate, err := getcooldownstate(ctx, tx, cooldowns, task.ID, team, now)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get cooldown state: "+err.Error())
+			}
+			res.NextSubmissionAt = cooldownstate.NextSubmissionAt
+
+			if idempotencykey != "" {
+				if err := saveidempotentresponse(ctx, tx, user.ID, idempotencykey, http.StatusOK, res); err != nil {
+					return echo.NewHTTPError(http.StatusInternalServerError, "failed to save idempotent response: "+err.Error())
+				}
+			}
+			if err := tx.Commit(); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit transaction: "+err.Error())
+			}
+			return c.JSON(http.StatusOK, res)
+		}
+	}
+
 	if submissionscount >= task.SubmissionLimit {
 		return echo.NewHTTPError(http.StatusBadRequest, "submission limit exceeded")
 	}
 
-	timestamp := time.Unix(req.Timestamp, 0)
+	// 提出間隔の制限を確認する
+	cooldowns, err := getcooldowns(ctx, tx, task.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get cooldowns: "+err.Error())
+	}
+	cooldownstate, err := getcooldownstate(ctx, tx, cooldowns, task.ID, team, now)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get cooldown state: "+err.Error())
+	}
+	if cooldownstate.NextSubmissionAt != 0 {
+		c.Response().Header().Set("Retry-After", strconv.FormatInt(cooldownstate.NextSubmissionAt-now.Unix(), 10))
+		return c.JSON(http.StatusTooManyRequests, CooldownErrorResponse{
+			Message:          "submission cooldown",
+			NextSubmissionAt: cooldownstate.NextSubmissionAt,
+		})
+	}
 
-	if _, err = tx.ExecContext(ctx, "INSERT INTO submissions (task_id, user_id, submitted_at, answer) VALUES (?, ?, ?, ?)", task.ID, user.ID, timestamp, req.Answer); err != nil {
+	// 非同期に判定する場合は判定待ちとして記録し、判定はワーカーに任せる
+	result := JudgeResult{Verdict: verdictpending}
+	if !asyncjudging {
+		result, err = judgeanswer(ctx, tx, task, targetSubtaskID, req.Answer, now)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to judge answer: "+err.Error())
+		}
+
+		// 小課題ごとの提出回数の制限を確認する。これから記録する提出はまだないので、すべての提出を数える
+		result, err = applyattemptlimit(ctx, tx, result, targetSubtaskID, team, 0)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get attempt count: "+err.Error())
+		}
+	}
+
+	// 判定結果も提出と一緒に記録しておく
+	subtaskID := nullsubtaskid
+	if result.IsScored {
+		subtaskID = result.Subtask.ID
+	}
+	insertres, err := tx.ExecContext(ctx, "INSERT INTO submissions (task_id, user_id, submitted_at, answer, target_subtask_id, subtask_id, score, verdict, client_submitted_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", task.ID, user.ID, now, req.Answer, targetSubtaskID, subtaskID, result.Score, result.Verdict, clienttimestamp)
+	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert submission: "+err.Error())
 	}
+	submissionID, err := insertres.LastInsertId()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get submission id: "+err.Error())
+	}
+	if len(result.CheckerErrors) > 0 {
+		if err := insertcheckerfailures(ctx, tx, int(submissionID), task.ID, result.CheckerErrors, now); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert checker failures: "+err.Error())
+		}
+	}
 
 	res := SubmitResponse{}
 
 	// デフォルトではこれを返す。答えが有効な場合は更新される。
+	res.SubmissionID = int(submissionID)
 	res.IsScored = false
 	res.Score = 0
+	res.Verdict = result.Verdict
+	res.Message = result.Message
 	res.RemainingSubmissions = task.SubmissionLimit - submissionscount - 1
 
-	subtasks := []Subtask{}
-	if err := tx.SelectContext(c.Request().Context(), &subtasks, "SELECT * FROM subtasks WHERE task_id = ?", task.ID); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get subtasks: "+err.Error())
-	}
-	for _, subtask := range subtasks {
-		answers := []Answer{}
-		if err := tx.SelectContext(c.Request().Context(), &answers, "SELECT * FROM answers WHERE subtask_id = ?", subtask.ID); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get answers: "+err.Error())
-		}
-		// SubTaskMaxScore は事前に計算しておく
-		subtaskmaxscore := 0
-		for _, answer := range answers {
-			if subtaskmaxscore < answer.Score {
-				subtaskmaxscore = answer.Score
-			}
+	// 答えが有効な場合、スコアを更新する
+	firstsolve := false
+	if result.IsScored {
+		res.IsScored = true
+		res.Score = result.Score
+		res.BaseScore = result.BaseScore
+		res.SubtaskName = result.Subtask.Name
+		res.SubTaskDisplayName = result.Subtask.DisplayName
+		res.SubTaskMaxScore = result.SubtaskMaxScore
+
+		if _, err := tx.ExecContext(ctx, "INSERT INTO subtask_scores_of_user (user_id, subtask_id, score) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE score = GREATEST(score, ?)", user.ID, result.Subtask.ID, result.Score, result.Score); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert subtask score: "+err.Error())
 		}
-		// 答えが有効な場合、スコアを更新する
-		for _, answer := range answers {
-			if answer.Answer == req.Answer {
-				res.IsScored = true
-				res.Score = answer.Score
-				res.SubtaskName = subtask.Name
-				res.SubTaskDisplayName = subtask.DisplayName
-				res.SubTaskMaxScore = subtaskmaxscore
-
-				if _, err := tx.ExecContext(ctx, "INSERT INTO subtask_scores_of_user (user_id, subtask_id, score) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE score = GREATEST(score, ?)", user.ID, subtask.ID, answer.Score, answer.Score); err != nil {
-					return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert subtask score: "+err.Error())
-				}
+		if result.Verdict == verdictaccepted {
+			if err := insertsubtasksolve(ctx, tx, result.Subtask.ID, team.ID, now); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert subtask solve: "+err.Error())
 			}
+			firstsolve, err = insertfirstsolve(ctx, tx, result.Subtask.ID, team.ID, user.ID, res.SubmissionID, now)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert first solve: "+err.Error())
+			}
+			res.IsFirstSolve = firstsolve
+		}
+	}
+
+	// 今回の提出を含めて、次に提出できる時刻を計算する
+	cooldownstate, err = getcooldownstate(ctx, tx, cooldowns, task.ID, team, now)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get cooldown state: "+err.Error())
+	}
+	res.NextSubmissionAt = cooldownstate.NextSubmissionAt
+
+	status := http.StatusCreated
+	if asyncjudging {
+		status = http.StatusAccepted
+	}
+
+	if idempotencykey != "" {
+		if err := saveidempotentresponse(ctx, tx, user.ID, idempotencykey, status, res); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to save idempotent response: "+err.Error())
 		}
 	}
 
@@ -776,183 +1109,193 @@ func submitHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit transaction: "+err.Error())
 	}
 
-	return c.JSON(http.StatusCreated, res)
+	// 判定待ちの提出も、提出したことは順位表に反映する
+	standingsengine.applysubmission(Submission{
+		ID:              res.SubmissionID,
+		TaskID:          task.ID,
+		UserID:          user.ID,
+		SubmittedAt:     now,
+		Answer:          req.Answer,
+		TargetSubtaskID: targetSubtaskID,
+		SubtaskID:       subtaskID,
+		Score:           result.Score,
+		Verdict:         result.Verdict,
+	})
+	if asyncjudging {
+		enqueuejudge(res.SubmissionID)
+	}
+
+	return c.JSON(status, res)
 }
 
 type SubmissionDetail struct {
-	TaskName           string `json:"task_name"`
-	TaskDisplayName    string `json:"task_display_name"`
-	SubTaskName        string `json:"subtask_name"`
-	SubTaskDisplayName string `json:"subtask_display_name"`
-	SubTaskMaxScore    int    `json:"subtask_max_score"`
-	UserName           string `json:"user_name"`
-	UserDisplayName    string `json:"user_display_name"`
-	SubmittedAt        int64  `json:"submitted_at"`
-	Answer             string `json:"answer"`
-	Score              int    `json:"score"`
+	ID                 int    `json:"id" db:"id"`
+	TaskName           string `json:"task_name" db:"task_name"`
+	TaskDisplayName    string `json:"task_display_name" db:"task_display_name"`
+	SubTaskName        string `json:"subtask_name" db:"subtask_name"`
+	SubTaskDisplayName string `json:"subtask_display_name" db:"subtask_display_name"`
+	SubTaskMaxScore    int    `json:"subtask_max_score" db:"subtask_max_score"`
+	UserID             int    `json:"-" db:"user_id"`
+	UserName           string `json:"user_name" db:"user_name"`
+	UserDisplayName    string `json:"user_display_name" db:"user_display_name"`
+	SubmittedAt        int64  `json:"submitted_at" db:"-"`
+	Answer             string `json:"answer" db:"answer"`
+	Score              int    `json:"score" db:"score"`
+	Verdict            string `json:"verdict" db:"verdict"`
 }
 
+// 提出の一覧を表示用の情報とともに取得するクエリ。WHERE 以降は呼び出し側で付け足す
+const submissionsquery = "SELECT submissions.id, submissions.user_id, submissions.submitted_at, submissions.answer, submissions.score, submissions.verdict," +
+	" tasks.name AS task_name, tasks.display_name AS task_display_name," +
+	" COALESCE(subtasks.name, '') AS subtask_name, COALESCE(subtasks.display_name, '') AS subtask_display_name," +
+	" GREATEST(COALESCE(subtask_max.max_score, 0), COALESCE(subtasks.max_score, 0)) AS subtask_max_score," +
+	" users.name AS user_name, users.display_name AS user_display_name" +
+	" FROM submissions" +
+	" JOIN tasks ON tasks.id = submissions.task_id" +
+	" JOIN users ON users.id = submissions.user_id" +
+	" LEFT JOIN subtasks ON subtasks.id = submissions.subtask_id" +
+	" LEFT JOIN (SELECT subtask_id, MAX(score) AS max_score FROM answers GROUP BY subtask_id) AS subtask_max ON subtask_max.subtask_id = submissions.subtask_id"
+
 type submissionresponse struct {
 	Submissions     []SubmissionDetail `json:"submissions"`
 	SubmissionCount int                `json:"submission_count"`
+	NextCursor      string             `json:"next_cursor,omitempty"` // 次のページがなければ空
 }
 
 // GET /api/submissions
 func getSubmissionsHandler(c echo.Context) error {
-	submissionsperpage := 20
+	ctx := c.Request().Context()
+
 	if err := verifyUserSession(c); err != nil {
 		return err
 	}
 
-	sess, _ := session.Get(defaultSessionIDKey, c)
-	username, _ := sess.Values[defaultSessionUserNameKey].(string)
-
-	user := User{}
-	if err := dbConn.GetContext(c.Request().Context(), &user, "SELECT * FROM users WHERE name = ?", username); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+	filter, err := parsesubmissionfilter(c)
+	if err != nil {
+		return err
 	}
-
-	team := Team{}
-	if username != "admin" {
-		err := dbConn.GetContext(c.Request().Context(), &team, "SELECT * FROM teams WHERE leader_id = ? OR member1_id = ? OR member2_id = ?", user.ID, user.ID, user.ID)
-		if err == sql.ErrNoRows {
-			return echo.NewHTTPError(http.StatusBadRequest, "you have not joined team")
-		} else if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get team: "+err.Error())
-		}
-	} else if c.QueryParam("team_name") != "" {
-		err := dbConn.GetContext(c.Request().Context(), &team, "SELECT * FROM teams WHERE name = ?", c.QueryParam("team_name"))
-		if err == sql.ErrNoRows {
-			return echo.NewHTTPError(http.StatusBadRequest, "team not found")
-		} else if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get team: "+err.Error())
-		}
+	order, err := parsesubmissionorder(c)
+	if err != nil {
+		return err
+	}
+	limit, err := parsesubmissionlimit(c)
+	if err != nil {
+		return err
 	}
 
-	conditions := make([]string, 0)
-	params := make([]interface{}, 0)
+	res := submissionresponse{}
 
-	if c.QueryParam("task_name") != "" {
-		task := Task{}
-		err := dbConn.GetContext(c.Request().Context(), &task, "SELECT * FROM tasks WHERE name = ?", c.QueryParam("task_name"))
-		if err == sql.ErrNoRows {
-			return echo.NewHTTPError(http.StatusBadRequest, "task not found")
-		} else if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get task: "+err.Error())
-		}
-		conditions = append(conditions, "task_id = ?")
-		params = append(params, task.ID)
-	}
-	if c.QueryParam("user_name") != "" {
-		user := User{}
-		err := dbConn.GetContext(c.Request().Context(), &user, "SELECT * FROM users WHERE name = ?", c.QueryParam("user_name"))
-		if err == sql.ErrNoRows {
-			return echo.NewHTTPError(http.StatusBadRequest, "user not found")
-		}
-		conditions = append(conditions, "user_id = ?")
-		params = append(params, user.ID)
-	}
-	if c.QueryParam("filter") != "" {
-		conditions = append(conditions, "answer LIKE CONCAT('%', ?, '%')")
-		params = append(params, c.QueryParam("filter"))
+	// 件数はカーソルによらず、絞り込み条件に一致する提出の総数を返す
+	if err := dbConn.GetContext(ctx, &res.SubmissionCount, "SELECT COUNT(*) FROM submissions"+filter.where(), filter.params...); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get submission count: "+err.Error())
 	}
 
-	if username != "admin" || c.QueryParam("team_name") != "" {
-		subconditions := "user_id = ?"
-		params = append(params, team.LeaderID)
-		if team.Member1ID != nulluserid {
-			subconditions += " OR user_id = ?"
-			params = append(params, team.Member1ID)
+	// cursor があればその続きから、なければ page (1-idx) のページを返す
+	offset := 0
+	if c.QueryParam("cursor") != "" {
+		cursor, err := decodesubmissioncursor(c.QueryParam("cursor"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid cursor: "+err.Error())
 		}
-		if team.Member2ID != nulluserid {
-			subconditions += " OR user_id = ?"
-			params = append(params, team.Member2ID)
+		if cursor.Order != order {
+			return echo.NewHTTPError(http.StatusBadRequest, "cursor does not match sort and order")
 		}
-		conditions = append(conditions, "("+subconditions+")")
+		filter.after(cursor)
+	} else if c.QueryParam("page") != "" {
+		page, err := strconv.Atoi(c.QueryParam("page"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "failed to parse page: "+err.Error())
+		}
+		if page < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, "page must be positive")
+		}
+		offset = (page - 1) * limit
 	}
 
-	submissions := []Submission{}
-	query := ""
-	if len(conditions) > 0 {
-		query = "SELECT * FROM submissions WHERE " + strings.Join(conditions, " AND ") + " ORDER BY submitted_at DESC"
-	} else {
-		query = "SELECT * FROM submissions ORDER BY submitted_at DESC"
+	// 判定結果は提出時に記録してあるので、表示に必要な情報は 1 回のクエリでまとめて取得する
+	type Res struct {
+		SubmissionDetail
+		SubmittedAt time.Time `db:"submitted_at"`
 	}
-	if err := dbConn.SelectContext(c.Request().Context(), &submissions, query, params...); err != nil {
+	var submissions []Res
+	// 次のページがあるか調べるため、1 件多く取得する
+	query := submissionsquery + filter.where() + order.orderby() + " LIMIT ? OFFSET ?"
+	if err := dbConn.SelectContext(ctx, &submissions, query, append(filter.params, limit+1, offset)...); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get submissions: "+err.Error())
 	}
+	if len(submissions) > limit {
+		submissions = submissions[:limit]
+		last := submissions[limit-1]
+		cursor := submissioncursor{Order: order, Value: last.SubmittedAt.Unix(), ID: last.ID}
+		if order.Sort == "score" {
+			cursor.Value = int64(last.Score)
+		}
+		res.NextCursor = encodesubmissioncursor(cursor)
+	}
 
-	submissiondata := []SubmissionDetail{}
+	res.Submissions = []SubmissionDetail{}
 	for _, submission := range submissions {
-		submissiondetail := SubmissionDetail{}
-		task := Task{}
-		if err := dbConn.GetContext(c.Request().Context(), &task, "SELECT * FROM tasks WHERE id = ?", submission.TaskID); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get task: "+err.Error())
-		}
-		submissiondetail.TaskName = task.Name
-		submissiondetail.TaskDisplayName = task.DisplayName
+		submissiondetail := submission.SubmissionDetail
+		submissiondetail.SubmittedAt = submission.SubmittedAt.Unix()
+		res.Submissions = append(res.Submissions, submissiondetail)
+	}
 
-		answer := Answer{}
-		err := dbConn.GetContext(c.Request().Context(), &answer, "SELECT * FROM answers WHERE task_id = ? AND answer = ?", task.ID, submission.Answer)
-		if err == sql.ErrNoRows {
-			submissiondetail.SubTaskName = ""
-			submissiondetail.SubTaskDisplayName = ""
-			submissiondetail.Score = 0
-			submissiondetail.SubTaskMaxScore = 0
-		} else if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get answer: "+err.Error())
-		} else {
-			subtask := Subtask{}
-			if err := dbConn.GetContext(c.Request().Context(), &subtask, "SELECT * FROM subtasks WHERE id = ?", answer.SubtaskID); err != nil {
-				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get subtask: "+err.Error())
-			}
-			submissiondetail.SubTaskName = subtask.Name
-			submissiondetail.SubTaskDisplayName = subtask.DisplayName
-			submissiondetail.Score = answer.Score
+	return c.JSON(http.StatusOK, res)
+}
 
-			if err := dbConn.GetContext(c.Request().Context(), &submissiondetail.SubTaskMaxScore, "SELECT MAX(score) FROM answers WHERE subtask_id = ?", subtask.ID); err != nil {
-				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get subtask score: "+err.Error())
-			}
-		}
+type SubmissionStatusResponse struct {
+	SubmissionDetail
+	Status string `json:"status"` // pending または judged
+}
 
-		user := User{}
-		if err := dbConn.GetContext(c.Request().Context(), &user, "SELECT * FROM users WHERE id = ?", submission.UserID); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
-		}
-		submissiondetail.UserName = user.Name
-		submissiondetail.UserDisplayName = user.DisplayName
-		submissiondetail.SubmittedAt = submission.SubmittedAt.Unix()
-		submissiondetail.Answer = submission.Answer
+// GET /api/submissions/:id
+// 提出 1 件の判定状況を返す。非同期に判定する場合のポーリング用
+func getSubmissionHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 
-		if c.QueryParam("subtask_name") == "" || c.QueryParam("subtask_name") == submissiondetail.SubTaskName {
-			submissiondata = append(submissiondata, submissiondetail)
-		}
+	if err := verifyUserSession(c); err != nil {
+		return err
 	}
 
-	page := 1 // 1-idx
-	if c.QueryParam("page") != "" {
-		var err error
-		page, err = strconv.Atoi(c.QueryParam("page"))
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, "failed to parse page: "+err.Error())
-		}
-	}
-	if page < 1 {
-		return echo.NewHTTPError(http.StatusBadRequest, "page must be positive")
+	submissionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to parse id: "+err.Error())
 	}
-	start := (page - 1) * submissionsperpage
-	end := start + submissionsperpage
 
-	if end > len(submissiondata) {
-		end = len(submissiondata)
+	type Res struct {
+		SubmissionDetail
+		SubmittedAt time.Time `db:"submitted_at"`
 	}
-	if start > end {
-		start = end
+	submission := Res{}
+	err = dbConn.GetContext(ctx, &submission, submissionsquery+" WHERE submissions.id = ?", submissionID)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "submission not found")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get submission: "+err.Error())
 	}
 
-	res := submissionresponse{
-		Submissions:     submissiondata[start:end],
-		SubmissionCount: len(submissiondata),
+	// admin 以外は自分のチームの提出のみ見られる
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	username, _ := sess.Values[defaultSessionUserNameKey].(string)
+	if username != "admin" {
+		_, team, err := getsessionteam(ctx, c, dbConn)
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusBadRequest, "you have not joined team")
+		} else if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get team: "+err.Error())
+		}
+		if !slices.Contains(teammemberids(team), submission.UserID) {
+			return echo.NewHTTPError(http.StatusNotFound, "submission not found")
+		}
+	}
+
+	res := SubmissionStatusResponse{
+		SubmissionDetail: submission.SubmissionDetail,
+		Status:           "judged",
+	}
+	res.SubmittedAt = submission.SubmittedAt.Unix()
+	if submission.Verdict == verdictpending {
+		res.Status = "pending"
 	}
 
 	return c.JSON(http.StatusOK, res)
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type SubmissionCooldown struct {
+	ID             int `db:"id"`
+	TaskID         int `db:"task_id"`
+	MaxSubmissions int `db:"max_submissions"`
+	WindowMinutes  int `db:"window_minutes"`
+}
+
+type CooldownRequest struct {
+	MaxSubmissions int `json:"max_submissions"`
+	WindowMinutes  int `json:"window_minutes"`
+}
+
+type CooldownRuleState struct {
+	MaxSubmissions int `json:"max_submissions"`
+	WindowMinutes  int `json:"window_minutes"`
+	Used           int `json:"used"` // 現在のウィンドウ内での提出数
+}
+type CooldownState struct {
+	NextSubmissionAt int64               `json:"next_submission_at"` // 次に提出できる時刻。すぐに提出できる場合は 0
+	Rules            []CooldownRuleState `json:"rules"`
+}
+
+// 問題の提出間隔の制限を取得する
+func getcooldowns(ctx context.Context, q sqlx.QueryerContext, taskID int) ([]SubmissionCooldown, error) {
+	cooldowns := []SubmissionCooldown{}
+	if err := sqlx.SelectContext(ctx, q, &cooldowns, "SELECT * FROM submission_cooldowns WHERE task_id = ? ORDER BY window_minutes", taskID); err != nil {
+		return nil, err
+	}
+	return cooldowns, nil
+}
+
+// チームの提出間隔の制限の状態を計算する
+// 「M 分間に N 回まで」の制限では、直近 N 回目の提出から M 分経つと次の提出ができる
+func getcooldownstate(ctx context.Context, q sqlx.QueryerContext, cooldowns []SubmissionCooldown, taskID int, team Team, now time.Time) (CooldownState, error) {
+	state := CooldownState{
+		NextSubmissionAt: 0,
+		Rules:            []CooldownRuleState{},
+	}
+	if len(cooldowns) == 0 {
+		return state, nil
+	}
+
+	longestwindow := 0
+	for _, cooldown := range cooldowns {
+		longestwindow = max(longestwindow, cooldown.WindowMinutes)
+	}
+
+	query, params, err := sqlx.In("SELECT submitted_at FROM submissions WHERE task_id = ? AND user_id IN (?) AND submitted_at > ? ORDER BY submitted_at DESC", taskID, teammemberids(team), now.Add(-time.Duration(longestwindow)*time.Minute))
+	if err != nil {
+		return CooldownState{}, err
+	}
+	submittedats := []time.Time{}
+	if err := sqlx.SelectContext(ctx, q, &submittedats, query, params...); err != nil {
+		return CooldownState{}, err
+	}
+
+	nextsubmissionat := now
+	for _, cooldown := range cooldowns {
+		window := time.Duration(cooldown.WindowMinutes) * time.Minute
+		used := 0
+		for _, submittedat := range submittedats {
+			if submittedat.After(now.Add(-window)) {
+				used++
+			}
+		}
+		if used >= cooldown.MaxSubmissions && cooldown.MaxSubmissions > 0 {
+			available := submittedats[cooldown.MaxSubmissions-1].Add(window)
+			if available.After(nextsubmissionat) {
+				nextsubmissionat = available
+			}
+		}
+		state.Rules = append(state.Rules, CooldownRuleState{
+			MaxSubmissions: cooldown.MaxSubmissions,
+			WindowMinutes:  cooldown.WindowMinutes,
+			Used:           used,
+		})
+	}
+	if nextsubmissionat.After(now) {
+		state.NextSubmissionAt = nextsubmissionat.Unix()
+	}
+
+	return state, nil
+}
+
+// 提出で指定された小課題の ID を返す。name が空なら nullsubtaskid
+func gettargetsubtaskid(ctx context.Context, q sqlx.QueryerContext, task Task, name string) (int, error) {
+	if name == "" {
+		return nullsubtaskid, nil
+	}
+	subtaskID := 0
+	if err := sqlx.GetContext(ctx, q, &subtaskID, "SELECT id FROM subtasks WHERE task_id = ? AND name = ?", task.ID, name); err != nil {
+		return 0, err
+	}
+	return subtaskID, nil
+}
+
+// 小課題を指定した提出はその小課題だけで判定する
+// 指定しない提出の不正解はどの小課題にも数えられないので、提出回数の制限がある小課題では判定しない
+func isjudgedsubtask(subtask Subtask, targetSubtaskID int) bool {
+	if targetSubtaskID != nullsubtaskid {
+		return subtask.ID == targetSubtaskID
+	}
+	return subtask.AttemptLimit == 0
+}
+
+// チームが小課題に対して行った提出の回数を返す
+// 小課題を指定した提出と、小課題を指定せずにその小課題で得点した提出を数える。無効にした提出は数えない
+// beforeID が 0 でなければ、ID がそれより小さい提出だけを数える
+func getsubtaskattemptcount(ctx context.Context, q sqlx.QueryerContext, subtask Subtask, team Team, beforeID int) (int, error) {
+	query := "SELECT COUNT(*) FROM submissions WHERE task_id = ? AND user_id IN (?) AND verdict != ? AND (target_subtask_id = ? OR (target_subtask_id = ? AND subtask_id = ?))"
+	params := []interface{}{subtask.TaskID, teammemberids(team), verdictinvalid, subtask.ID, nullsubtaskid, subtask.ID}
+	if beforeID != 0 {
+		query += " AND id < ?"
+		params = append(params, beforeID)
+	}
+	query, params, err := sqlx.In(query, params...)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	if err := sqlx.GetContext(ctx, q, &count, query, params...); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// 指定した小課題の提出回数の制限を超えていれば、得点を与えず制限超過とする
+// 不正解でも制限超過として記録し、提出自体は記録する
+func applyattemptlimit(ctx context.Context, q sqlx.QueryerContext, result JudgeResult, targetSubtaskID int, team Team, submissionID int) (JudgeResult, error) {
+	if targetSubtaskID == nullsubtaskid {
+		return result, nil
+	}
+	subtask := Subtask{}
+	if err := sqlx.GetContext(ctx, q, &subtask, "SELECT * FROM subtasks WHERE id = ?", targetSubtaskID); err != nil {
+		return JudgeResult{}, err
+	}
+	if subtask.AttemptLimit == 0 {
+		return result, nil
+	}
+	attemptcount, err := getsubtaskattemptcount(ctx, q, subtask, team, submissionID)
+	if err != nil {
+		return JudgeResult{}, err
+	}
+	if attemptcount < subtask.AttemptLimit {
+		return result, nil
+	}
+	return JudgeResult{
+		Verdict:       verdictattemptlimit,
+		Message:       "subtask attempt limit exceeded",
+		CheckerErrors: result.CheckerErrors,
+	}, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 動的配点の問題かどうか。小課題を解いたチームが増えるほど、その小課題の配点が下がる
+func (task Task) isdynamic() bool {
+	return task.DynamicDecay > 0
+}
+
+// solves チームが解いた小課題の配点
+// 解いたチーム数の 2 乗で initial から minimum まで下がり、decay チームで minimum になる
+func (task Task) dynamicvalue(solves int) int {
+	value := task.DynamicInitial + (task.DynamicMinimum-task.DynamicInitial)*solves*solves/(task.DynamicDecay*task.DynamicDecay)
+	return max(value, task.DynamicMinimum)
+}
+
+// 記録されている得点を、満点 basemaxscore に対する割合を保ったまま配点 value に換算する
+func scaledynamicscore(score int, basemaxscore int, value int) int {
+	if basemaxscore <= 0 {
+		return 0
+	}
+	return score * value / basemaxscore
+}
+
+// 動的配点の問題の小課題について、現在の配点を返す
+// 解いたチーム数は subtask_solves の主キーだけで数えられるので、提出が増えても重くならない
+func getdynamicvalues(ctx context.Context, q sqlx.QueryerContext, tasks []Task, subtasks []Subtask) (map[int]int, error) {
+	values := map[int]int{}
+
+	dynamictasks := map[int]Task{}
+	for _, task := range tasks {
+		if task.isdynamic() {
+			dynamictasks[task.ID] = task
+		}
+	}
+	if len(dynamictasks) == 0 {
+		return values, nil
+	}
+
+	type Res struct {
+		SubtaskID int `db:"subtask_id"`
+		Solves    int `db:"solves"`
+	}
+	var solves []Res
+	if err := sqlx.SelectContext(ctx, q, &solves, "SELECT subtask_id, COUNT(*) AS solves FROM subtask_solves GROUP BY subtask_id"); err != nil {
+		return nil, err
+	}
+	solvecounts := map[int]int{}
+	for _, s := range solves {
+		solvecounts[s.SubtaskID] = s.Solves
+	}
+
+	for _, subtask := range subtasks {
+		if task, ok := dynamictasks[subtask.TaskID]; ok {
+			values[subtask.ID] = task.dynamicvalue(solvecounts[subtask.ID])
+		}
+	}
+	return values, nil
+}
+
+// ユーザーごと・小課題ごとの得点を、動的配点の小課題について現在の配点に換算する
+func scaledynamicscores(scores map[int](map[int]int), basemaxscores map[int]int, values map[int]int) {
+	if len(values) == 0 {
+		return
+	}
+	for _, userscores := range scores {
+		for subtaskID, score := range userscores {
+			if value, ok := values[subtaskID]; ok {
+				userscores[subtaskID] = scaledynamicscore(score, basemaxscores[subtaskID], value)
+			}
+		}
+	}
+}
+
+// チームが小課題を満点で解いたことを記録する
+// 非同期の判定では提出の順に記録されるとは限らないので、rebuildsubtasksolves と同じく最も早い提出時刻を残す
+func insertsubtasksolve(ctx context.Context, tx *sqlx.Tx, subtaskID int, teamID int, at time.Time) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO subtask_solves (subtask_id, team_id, solved_at) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE solved_at = LEAST(solved_at, VALUES(solved_at))", subtaskID, teamID, at)
+	return err
+}
+
+// 対象の範囲の小課題について、subtask_solves を記録されている提出から作り直す
+// 解いたチーム数はすべてのチームで数えるので、ユーザーの絞り込みは使わない
+func rebuildsubtasksolves(ctx context.Context, tx *sqlx.Tx, scope rejudgescope) error {
+	where := ""
+	params := []interface{}{}
+	if scope.subtaskID != 0 {
+		where = " AND subtask_id = ?"
+		params = append(params, scope.subtaskID)
+	} else if scope.taskID != 0 {
+		where = " AND subtask_id IN (SELECT id FROM subtasks WHERE task_id = ?)"
+		params = append(params, scope.taskID)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM subtask_solves WHERE 1 = 1"+where, params...); err != nil {
+		return err
+	}
+	insertparams := append([]interface{}{verdictaccepted}, params...)
+	if _, err := tx.ExecContext(ctx, "INSERT INTO subtask_solves (subtask_id, team_id, solved_at)"+
+		" SELECT submissions.subtask_id, teams.id, MIN(submissions.submitted_at) FROM submissions"+
+		" JOIN teams ON submissions.user_id IN (teams.leader_id, teams.member1_id, teams.member2_id)"+
+		" WHERE submissions.verdict = ?"+where+
+		" GROUP BY submissions.subtask_id, teams.id", insertparams...); err != nil {
+		return err
+	}
+	return nil
+}
+
+// 動的配点の設定。問題作成のリクエストと問題の詳細で使う
+type DynamicScoring struct {
+	Initial int `json:"initial"` // 誰も解いていないときの小課題の配点
+	Minimum int `json:"minimum"`
+	Decay   int `json:"decay"` // 配点が minimum になるまでに解くチーム数
+}
+
+func (task Task) dynamicscoring() *DynamicScoring {
+	if !task.isdynamic() {
+		return nil
+	}
+	return &DynamicScoring{
+		Initial: task.DynamicInitial,
+		Minimum: task.DynamicMinimum,
+		Decay:   task.DynamicDecay,
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// イベントフィードの 1 行。ICPC の Contest API のイベントフィードに合わせる
+type ContestEvent struct {
+	ID   string      `json:"id"`
+	Type string      `json:"type"`
+	Op   string      `json:"op"`
+	Data interface{} `json:"data"`
+
+	key      string    // 元になった行ごとのキー。イベントログで前回の内容と比べる
+	at       time.Time // 並べ替えに使う時刻
+	priority int       // 同じ時刻のイベントの順番
+}
+
+type ContestEventData struct {
+	ID                       string `json:"id"`
+	Name                     string `json:"name"`
+	StartTime                string `json:"start_time,omitempty"`
+	Duration                 string `json:"duration,omitempty"`
+	ScoreboardFreezeDuration string `json:"scoreboard_freeze_duration,omitempty"`
+	PenaltyTime              int    `json:"penalty_time"`
+}
+type JudgementTypeEventData struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Penalty bool   `json:"penalty"`
+	Solved  bool   `json:"solved"`
+}
+type ProblemEventData struct {
+	ID       string `json:"id"`
+	Label    string `json:"label"`
+	Name     string `json:"name"`
+	Ordinal  int    `json:"ordinal"`
+	MaxScore int    `json:"max_score"`
+}
+type TeamEventData struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Time        string `json:"time"`
+}
+type SubmissionEventData struct {
+	ID          string `json:"id"`
+	TeamID      string `json:"team_id"`
+	ProblemID   string `json:"problem_id"`
+	Time        string `json:"time"`
+	ContestTime string `json:"contest_time,omitempty"`
+}
+type JudgementEventData struct {
+	ID              string `json:"id"`
+	SubmissionID    string `json:"submission_id"`
+	JudgementTypeID string `json:"judgement_type_id"`
+	Score           int    `json:"score"`
+	StartTime       string `json:"start_time"`
+	EndTime         string `json:"end_time"`
+}
+type ScoreEventData struct {
+	TeamID      string `json:"team_id"`
+	Score       int    `json:"score"`
+	Time        string `json:"time"`
+	ContestTime string `json:"contest_time,omitempty"`
+}
+
+// 判定結果と ICPC の判定の種類の対応
+var judgementtypes = []JudgementTypeEventData{
+	{ID: "AC", Name: "accepted", Penalty: false, Solved: true},
+	{ID: "PA", Name: "partial", Penalty: false, Solved: false},
+	{ID: "WA", Name: "wrong answer", Penalty: true, Solved: false},
+	{ID: "JE", Name: "judging error", Penalty: false, Solved: false},
+	{ID: "AL", Name: "attempt limit exceeded", Penalty: false, Solved: false},
+}
+
+var judgementtypeofverdict = map[string]string{
+	verdictaccepted:     "AC",
+	verdictpartial:      "PA",
+	verdictwrong:        "WA",
+	verdictchecker:      "JE",
+	verdictattemptlimit: "AL",
+}
+
+// ISO 8601 の時刻
+func eventtime(at time.Time) string {
+	return at.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+}
+
+// ICPC の RELTIME 形式の経過時間 (h:mm:ss.sss)
+func eventreltime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// コンテスト開始からの経過時間。開始時刻の設定がなければ空
+func eventcontesttime(at time.Time) string {
+	if conteststart.IsZero() {
+		return ""
+	}
+	return eventreltime(at.Sub(conteststart))
+}
+
+// 現在のデータから作ったコンテストのイベントを時刻順に返す
+// ID はまだ付けない。イベントログに書いたときに付く
+func getcontestevents(ctx context.Context, q sqlx.QueryerContext) ([]ContestEvent, error) {
+	events := []ContestEvent{}
+
+	// 時刻によらない設定のイベントを先に並べる
+	contest := ContestEventData{ID: "risucon", Name: "RISUCON", PenaltyTime: tiebreakpenaltyminutes}
+	if !conteststart.IsZero() {
+		contest.StartTime = eventtime(conteststart)
+		if !contestend.IsZero() {
+			contest.Duration = eventreltime(contestend.Sub(conteststart))
+		}
+	}
+	if !scoreboardfreezeat().IsZero() {
+		contest.ScoreboardFreezeDuration = eventreltime(time.Duration(freezeminutes) * time.Minute)
+	}
+	events = append(events, ContestEvent{Type: "contests", Op: "create", key: "contests/" + contest.ID, Data: contest})
+	for _, judgementtype := range judgementtypes {
+		events = append(events, ContestEvent{Type: "judgement-types", Op: "create", key: "judgement-types/" + judgementtype.ID, Data: judgementtype})
+	}
+
+	state, err := loadstandingsbase(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	for i, task := range state.tasksdata() {
+		events = append(events, ContestEvent{Type: "problems", Op: "create", key: "problems/" + task.Name, Data: ProblemEventData{
+			ID:       task.Name,
+			Label:    task.Name,
+			Name:     task.DisplayName,
+			Ordinal:  i,
+			MaxScore: task.MaxScore,
+		}})
+	}
+	// ここまでは時刻を持たないので、先頭に並べる
+	for i := range events {
+		events[i].priority = -1
+	}
+
+	teamids := map[string]int{}
+	for _, team := range state.teams {
+		teamids[team.Name] = team.ID
+		events = append(events, ContestEvent{Type: "teams", Op: "create", key: "teams/" + strconv.Itoa(team.ID), at: team.CreatedAt, Data: TeamEventData{
+			ID:          team.Name,
+			Name:        team.Name,
+			DisplayName: team.DisplayName,
+			Time:        eventtime(team.CreatedAt),
+		}})
+	}
+
+	// 判定待ちの提出と無効にした提出は判定を載せない
+	type Res struct {
+		ID          int       `db:"id"`
+		TeamName    string    `db:"team_name"`
+		TaskName    string    `db:"task_name"`
+		Score       int       `db:"score"`
+		Verdict     string    `db:"verdict"`
+		SubmittedAt time.Time `db:"submitted_at"`
+	}
+	var submissions []Res
+	if err := sqlx.SelectContext(ctx, q, &submissions, "SELECT submissions.id, teams.name AS team_name, tasks.name AS task_name, submissions.score, submissions.verdict, submissions.submitted_at"+
+		" FROM submissions"+
+		" JOIN tasks ON tasks.id = submissions.task_id"+
+		" JOIN teams ON submissions.user_id IN (teams.leader_id, teams.member1_id, teams.member2_id)"); err != nil {
+		return nil, err
+	}
+	for _, submission := range submissions {
+		id := strconv.Itoa(submission.ID)
+		events = append(events, ContestEvent{Type: "submissions", Op: "create", key: "submissions/" + id, at: submission.SubmittedAt, priority: 1, Data: SubmissionEventData{
+			ID:          id,
+			TeamID:      submission.TeamName,
+			ProblemID:   submission.TaskName,
+			Time:        eventtime(submission.SubmittedAt),
+			ContestTime: eventcontesttime(submission.SubmittedAt),
+		}})
+		if submission.Verdict == verdictpending || submission.Verdict == verdictinvalid {
+			continue
+		}
+		// 判定は提出時に行うので、判定の時刻は提出の時刻とする
+		events = append(events, ContestEvent{Type: "judgements", Op: "create", key: "judgements/" + id, at: submission.SubmittedAt, priority: 2, Data: JudgementEventData{
+			ID:              id,
+			SubmissionID:    id,
+			JudgementTypeID: judgementtypeofverdict[submission.Verdict],
+			Score:           submission.Score,
+			StartTime:       eventtime(submission.SubmittedAt),
+			EndTime:         eventtime(submission.SubmittedAt),
+		}})
+	}
+
+	histories, err := replaycache.scorehistory(ctx, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	for _, history := range histories {
+		for _, point := range history.Points {
+			at := time.Unix(point.At, 0)
+			key := fmt.Sprintf("scores/%d/%d", teamids[history.TeamName], point.At)
+			events = append(events, ContestEvent{Type: "scores", Op: "create", key: key, at: at, priority: 3, Data: ScoreEventData{
+				TeamID:      history.TeamName,
+				Score:       point.TotalScore,
+				Time:        eventtime(at),
+				ContestTime: eventcontesttime(at),
+			}})
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].priority < 0 || events[j].priority < 0 {
+			return events[i].priority < events[j].priority
+		}
+		if !events[i].at.Equal(events[j].at) {
+			return events[i].at.Before(events[j].at)
+		}
+		return events[i].priority < events[j].priority
+	})
+	return events, nil
+}
+
+// イベントログへの書き込みを 1 つずつにする
+var contesteventsmu sync.Mutex
+
+// 現在のデータから作ったイベントをイベントログと比べて、変わったものだけを追記する
+// 初めて出たキーは create、内容が変わったキーは update、なくなったキーは delete にする
+// ログの ID は追記順に増えるだけなので、since に使える
+func synccontestevents(ctx context.Context) error {
+	contesteventsmu.Lock()
+	defer contesteventsmu.Unlock()
+
+	events, err := getcontestevents(ctx, dbConn)
+	if err != nil {
+		return err
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// キーごとの最後のイベント
+	type Res struct {
+		Key  string `db:"event_key"`
+		Type string `db:"type"`
+		Op   string `db:"op"`
+		Data string `db:"data"`
+	}
+	var logged []Res
+	if err := tx.SelectContext(ctx, &logged, "SELECT event_key, type, op, data FROM contest_events"+
+		" WHERE id IN (SELECT MAX(id) FROM contest_events GROUP BY event_key)"+
+		" ORDER BY id"); err != nil {
+		return err
+	}
+	lasts := map[string]Res{}
+	for _, last := range logged {
+		lasts[last.Key] = last
+	}
+
+	now := time.Now()
+	insert := func(key, eventtype, op, data string) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO contest_events (event_key, type, op, data, created_at) VALUES (?, ?, ?, ?, ?)", key, eventtype, op, data, now)
+		return err
+	}
+	seen := map[string]bool{}
+	for _, event := range events {
+		seen[event.key] = true
+		data, err := json.Marshal(event.Data)
+		if err != nil {
+			return err
+		}
+		last, ok := lasts[event.key]
+		switch {
+		case !ok || last.Op == "delete":
+			err = insert(event.key, event.Type, "create", string(data))
+		case last.Data != string(data):
+			err = insert(event.key, event.Type, "update", string(data))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// 無効にした提出の判定や、再判定で消えた得点の変化
+	for _, last := range logged {
+		if seen[last.Key] || last.Op == "delete" {
+			continue
+		}
+		if err := insert(last.Key, last.Type, "delete", last.Data); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GET /api/admin/events
+// コンテストのイベント (チームの作成、提出の判定、得点の変化) を NDJSON で返す
+// since を指定すると、その ID より後のイベントだけを返す
+// ID はイベントログの追記順なので、再判定や遅れて届いた判定は後ろの ID で update として返す
+func getEventFeedHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyAdminSession(c); err != nil {
+		return err
+	}
+
+	since := 0
+	if c.QueryParam("since") != "" {
+		var err error
+		since, err = strconv.Atoi(c.QueryParam("since"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "failed to parse since: "+err.Error())
+		}
+	}
+
+	if err := synccontestevents(ctx); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to sync events: "+err.Error())
+	}
+
+	type Res struct {
+		ID   int    `db:"id"`
+		Type string `db:"type"`
+		Op   string `db:"op"`
+		Data string `db:"data"`
+	}
+	var rows []Res
+	if err := dbConn.SelectContext(ctx, &rows, "SELECT id, type, op, data FROM contest_events WHERE id > ? ORDER BY id", since); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get events: "+err.Error())
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "application/x-ndjson; charset=utf-8")
+	res.WriteHeader(http.StatusOK)
+
+	jsonencoder := json.NewEncoder(res)
+	for _, row := range rows {
+		event := ContestEvent{ID: strconv.Itoa(row.ID), Type: row.Type, Op: row.Op, Data: json.RawMessage(row.Data)}
+		if err := jsonencoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
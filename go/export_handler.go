@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// エクスポートする提出 1 件
+type SubmissionExportRow struct {
+	ID              int       `json:"id" db:"id"`
+	SubmittedAt     time.Time `json:"submitted_at" db:"submitted_at"`
+	UserName        string    `json:"user_name" db:"user_name"`
+	UserDisplayName string    `json:"user_display_name" db:"user_display_name"`
+	TeamName        string    `json:"team_name" db:"team_name"`
+	TeamDisplayName string    `json:"team_display_name" db:"team_display_name"`
+	TaskName        string    `json:"task_name" db:"task_name"`
+	SubTaskName     string    `json:"subtask_name" db:"subtask_name"`
+	Answer          string    `json:"answer" db:"answer"`
+	Score           int       `json:"score" db:"score"`
+	Verdict         string    `json:"verdict" db:"verdict"`
+}
+
+var submissionexportcolumns = []string{"id", "submitted_at", "user_name", "user_display_name", "team_name", "team_display_name", "task_name", "subtask_name", "answer", "score", "verdict"}
+
+func (row SubmissionExportRow) csvrecord() []string {
+	return []string{
+		strconv.Itoa(row.ID),
+		row.SubmittedAt.UTC().Format(time.RFC3339),
+		row.UserName,
+		row.UserDisplayName,
+		row.TeamName,
+		row.TeamDisplayName,
+		row.TaskName,
+		row.SubTaskName,
+		row.Answer,
+		strconv.Itoa(row.Score),
+		row.Verdict,
+	}
+}
+
+// submissionsquery と同じ FROM 句に、チームの情報を加えたもの
+const submissionsexportquery = "SELECT submissions.id, submissions.submitted_at, submissions.answer, submissions.score, submissions.verdict," +
+	" users.name AS user_name, users.display_name AS user_display_name," +
+	" COALESCE(teams.name, '') AS team_name, COALESCE(teams.display_name, '') AS team_display_name," +
+	" tasks.name AS task_name, COALESCE(subtasks.name, '') AS subtask_name" +
+	" FROM submissions" +
+	" JOIN tasks ON tasks.id = submissions.task_id" +
+	" JOIN users ON users.id = submissions.user_id" +
+	" LEFT JOIN teams ON submissions.user_id IN (teams.leader_id, teams.member1_id, teams.member2_id)" +
+	" LEFT JOIN subtasks ON subtasks.id = submissions.subtask_id"
+
+// GET /api/admin/submissions/export
+// 提出を CSV (format=csv) または NDJSON (format=ndjson) で書き出す
+// 絞り込み条件と並び順は GET /api/submissions と同じ
+func exportSubmissionsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyAdminSession(c); err != nil {
+		return err
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "ndjson" {
+		return echo.NewHTTPError(http.StatusBadRequest, "format must be csv or ndjson")
+	}
+
+	filter, err := parsesubmissionfilter(c)
+	if err != nil {
+		return err
+	}
+	order, err := parsesubmissionorder(c)
+	if err != nil {
+		return err
+	}
+
+	// 全件をメモリに載せないよう、1 行ずつ読んで書き出す
+	rows, err := dbConn.QueryxContext(ctx, submissionsexportquery+filter.where()+order.orderby(), filter.params...)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get submissions: "+err.Error())
+	}
+	defer rows.Close()
+
+	res := c.Response()
+	if format == "csv" {
+		res.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+		res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="submissions.csv"`)
+	} else {
+		res.Header().Set(echo.HeaderContentType, "application/x-ndjson; charset=utf-8")
+		res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="submissions.ndjson"`)
+	}
+	res.WriteHeader(http.StatusOK)
+
+	// ヘッダーを送った後はステータスを変えられないので、エラーはログに残して接続を切ることで伝える
+	// エラーを返すと、途中までの出力が正常に終わったように見えてしまう
+	abort := func(err error) {
+		c.Logger().Errorf("failed to export submissions: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+
+	csvwriter := csv.NewWriter(res)
+	jsonencoder := json.NewEncoder(res)
+	if format == "csv" {
+		if err := csvwriter.Write(submissionexportcolumns); err != nil {
+			abort(err)
+		}
+	}
+
+	written := 0
+	for rows.Next() {
+		row := SubmissionExportRow{}
+		if err := rows.StructScan(&row); err != nil {
+			abort(err)
+		}
+		// CSV と NDJSON のどちらも UTC で書き出す
+		row.SubmittedAt = row.SubmittedAt.UTC()
+		if format == "csv" {
+			err = csvwriter.Write(row.csvrecord())
+		} else {
+			err = jsonencoder.Encode(row)
+		}
+		if err != nil {
+			abort(err)
+		}
+
+		written++
+		if written%1000 == 0 {
+			csvwriter.Flush()
+			res.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		abort(err)
+	}
+
+	csvwriter.Flush()
+	if err := csvwriter.Error(); err != nil {
+		abort(err)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// checker の名前が "external:<ファイル名>" の場合、checkerdir にある実行ファイルで判定する
+//
+// 実行ファイルには標準入力に ExternalCheckerInput を JSON で渡し、
+// 標準出力に ExternalCheckerOutput を JSON で出力してもらう
+const externalcheckerprefix = "external:"
+
+var (
+	// 実行時には作業ディレクトリを checkerdir にするので、相対パスは起動時に絶対パスにしておく
+	checkerdir = func() string {
+		dir := getEnv("RISUCON_CHECKER_DIR", "../checkers")
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return dir
+		}
+		return abs
+	}()
+	// 1 回の実行の制限時間
+	checkertimeout = time.Duration(getEnvInt("RISUCON_CHECKER_TIMEOUT_MS", 5000)) * time.Millisecond
+	// 終了後や制限時間の経過後に、出力のパイプが閉じられるのを待つ時間
+	// checker が起動した子プロセスがパイプを開いたままにしても、これ以上は待たない
+	checkerwaitdelay = 500 * time.Millisecond
+	// 標準出力・標準エラー出力それぞれの上限 (バイト)
+	checkeroutputlimit = getEnvInt("RISUCON_CHECKER_OUTPUT_LIMIT", 64*1024)
+	// 同時に実行する checker の数の上限
+	checkersemaphore = make(chan struct{}, getEnvInt("RISUCON_CHECKER_CONCURRENCY", runtime.NumCPU()))
+)
+
+type ExternalCheckerInput struct {
+	TaskName    string `json:"task_name"`
+	SubtaskName string `json:"subtask_name"`
+	MaxScore    int    `json:"max_score"`
+	Answer      string `json:"answer"`
+}
+type ExternalCheckerOutput struct {
+	IsScored *bool  `json:"is_scored"` // 省略した場合は score が正なら得点とする
+	Score    int    `json:"score"`
+	Message  string `json:"message"`
+}
+
+// checker の実行そのものに失敗したことを表す。不正解とは区別して管理者に報告する
+type CheckerError struct {
+	SubtaskID int // judgeanswer が設定する
+	Checker   string
+	Reason    string // timeout, exit, output_limit, invalid_output など
+	Detail    string // 標準エラー出力など
+}
+
+func (e *CheckerError) Error() string {
+	return fmt.Sprintf("checker %s failed: %s", e.Checker, e.Reason)
+}
+
+// 上限を超えて書き込まれたら失敗する Writer
+type limitedbuffer struct {
+	bytes.Buffer
+	limit    int
+	exceeded bool
+}
+
+func (b *limitedbuffer) Write(p []byte) (int, error) {
+	if b.Buffer.Len()+len(p) > b.limit {
+		b.exceeded = true
+		b.Buffer.Write(p[:b.limit-b.Buffer.Len()])
+		return 0, errors.New("output limit exceeded")
+	}
+	return b.Buffer.Write(p)
+}
+
+// checkerdir の中の実行ファイルのパスを返す。ディレクトリの外を指す名前は受け付けない
+func externalcheckerpath(filename string) (string, error) {
+	if filename == "" || filepath.IsAbs(filename) || filename != filepath.Clean(filename) || strings.HasPrefix(filename, "..") {
+		return "", fmt.Errorf("invalid checker file name %q", filename)
+	}
+	path := filepath.Join(checkerdir, filename)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return "", fmt.Errorf("%s is not executable", path)
+	}
+	return path, nil
+}
+
+type externalchecker struct {
+	filename string
+}
+
+func (checker externalchecker) Check(ctx context.Context, q sqlx.QueryerContext, task Task, subtask Subtask, answer string) (CheckResult, error) {
+	name := externalcheckerprefix + checker.filename
+	path, err := externalcheckerpath(checker.filename)
+	if err != nil {
+		return CheckResult{}, &CheckerError{Checker: name, Reason: "not_found", Detail: err.Error()}
+	}
+
+	maxscore, err := getsubtaskmaxscore(ctx, q, subtask)
+	if err != nil {
+		return CheckResult{}, err
+	}
+	input, err := json.Marshal(ExternalCheckerInput{
+		TaskName:    task.Name,
+		SubtaskName: subtask.Name,
+		MaxScore:    maxscore,
+		Answer:      answer,
+	})
+	if err != nil {
+		return CheckResult{}, err
+	}
+
+	select {
+	case checkersemaphore <- struct{}{}:
+		defer func() { <-checkersemaphore }()
+	case <-ctx.Done():
+		return CheckResult{}, ctx.Err()
+	}
+
+	runctx, cancel := context.WithTimeout(ctx, checkertimeout)
+	defer cancel()
+
+	stdout := &limitedbuffer{limit: checkeroutputlimit}
+	stderr := &limitedbuffer{limit: checkeroutputlimit}
+	cmd := exec.CommandContext(runctx, path)
+	cmd.Dir = checkerdir
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = checkerwaitdelay
+	err = cmd.Run()
+
+	switch {
+	case runctx.Err() == context.DeadlineExceeded:
+		return CheckResult{}, &CheckerError{Checker: name, Reason: "timeout", Detail: stderr.String()}
+	case ctx.Err() != nil:
+		// リクエスト自体が中断された場合は checker の失敗として扱わない
+		return CheckResult{}, ctx.Err()
+	case stdout.exceeded || stderr.exceeded:
+		return CheckResult{}, &CheckerError{Checker: name, Reason: "output_limit", Detail: stderr.String()}
+	case err != nil:
+		return CheckResult{}, &CheckerError{Checker: name, Reason: "exit", Detail: err.Error() + "\n" + stderr.String()}
+	}
+
+	output := ExternalCheckerOutput{}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return CheckResult{}, &CheckerError{Checker: name, Reason: "invalid_output", Detail: err.Error() + "\n" + stdout.String()}
+	}
+	if output.Score < 0 || output.Score > maxscore {
+		return CheckResult{}, &CheckerError{Checker: name, Reason: "invalid_score", Detail: fmt.Sprintf("score %d is out of range [0, %d]", output.Score, maxscore)}
+	}
+
+	isscored := output.Score > 0
+	if output.IsScored != nil {
+		isscored = *output.IsScored
+	}
+	return CheckResult{
+		IsScored: isscored,
+		Score:    output.Score,
+		Message:  output.Message,
+	}, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// 小課題を最初に満点で解いたチームを記録する。この提出が最初に解いたものになった場合に true を返す
+// rebuildfirstsolves と同じく、満点の提出のうち ID が最小のものを最初に解いたものとする
+// 判定の順番は提出の順番と限らないので、記録済みの提出より ID が小さければ置き換える
+func insertfirstsolve(ctx context.Context, tx *sqlx.Tx, subtaskID int, teamID int, userID int, submissionID int, at time.Time) (bool, error) {
+	// submission_id は比較に使うので、最後に更新する
+	if _, err := tx.ExecContext(ctx, "INSERT INTO first_solves (subtask_id, team_id, user_id, submission_id, solved_at) VALUES (?, ?, ?, ?, ?)"+
+		" ON DUPLICATE KEY UPDATE"+
+		" team_id = IF(VALUES(submission_id) < submission_id, VALUES(team_id), team_id),"+
+		" user_id = IF(VALUES(submission_id) < submission_id, VALUES(user_id), user_id),"+
+		" solved_at = IF(VALUES(submission_id) < submission_id, VALUES(solved_at), solved_at),"+
+		" submission_id = LEAST(VALUES(submission_id), submission_id)", subtaskID, teamID, userID, submissionID, at); err != nil {
+		return false, err
+	}
+	// 影響を受けた行数では、同じ提出を再び記録した場合などを区別できないので、記録された提出を読み直す
+	firstsubmissionID := 0
+	if err := tx.GetContext(ctx, &firstsubmissionID, "SELECT submission_id FROM first_solves WHERE subtask_id = ?", subtaskID); err != nil {
+		return false, err
+	}
+	return firstsubmissionID == submissionID, nil
+}
+
+// 対象の範囲の小課題について、first_solves を記録されている提出から作り直す
+// 提出の ID は記録した順なので、満点の提出のうち ID が最小のものを最初に解いたものとする
+func rebuildfirstsolves(ctx context.Context, tx *sqlx.Tx, scope rejudgescope) error {
+	where := ""
+	params := []interface{}{}
+	if scope.subtaskID != 0 {
+		where = " AND subtask_id = ?"
+		params = append(params, scope.subtaskID)
+	} else if scope.taskID != 0 {
+		where = " AND subtask_id IN (SELECT id FROM subtasks WHERE task_id = ?)"
+		params = append(params, scope.taskID)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM first_solves WHERE 1 = 1"+where, params...); err != nil {
+		return err
+	}
+	insertparams := append([]interface{}{verdictaccepted}, params...)
+	if _, err := tx.ExecContext(ctx, "INSERT IGNORE INTO first_solves (subtask_id, team_id, user_id, submission_id, solved_at)"+
+		" SELECT submissions.subtask_id, teams.id, submissions.user_id, submissions.id, submissions.submitted_at FROM submissions"+
+		" JOIN teams ON submissions.user_id IN (teams.leader_id, teams.member1_id, teams.member2_id)"+
+		" WHERE submissions.id IN (SELECT MIN(id) FROM submissions WHERE verdict = ?"+where+" GROUP BY subtask_id)", insertparams...); err != nil {
+		return err
+	}
+	return nil
+}
+
+type TaskFirstSolve struct {
+	SubtaskID       int       `db:"subtask_id"`
+	TeamID          int       `db:"team_id"`
+	TeamName        string    `db:"team_name"`
+	TeamDisplayName string    `db:"team_display_name"`
+	SolvedAt        time.Time `db:"solved_at"`
+}
+
+// 問題の小課題ごとに、最初に解いたチームを返す
+func gettaskfirstsolves(ctx context.Context, q sqlx.QueryerContext, taskID int) (map[int]TaskFirstSolve, error) {
+	var rows []TaskFirstSolve
+	if err := sqlx.SelectContext(ctx, q, &rows, "SELECT first_solves.subtask_id, first_solves.team_id, teams.name AS team_name, teams.display_name AS team_display_name, first_solves.solved_at"+
+		" FROM first_solves"+
+		" JOIN subtasks ON subtasks.id = first_solves.subtask_id"+
+		" JOIN teams ON teams.id = first_solves.team_id"+
+		" WHERE subtasks.task_id = ?", taskID); err != nil {
+		return nil, err
+	}
+	firstsolves := map[int]TaskFirstSolve{}
+	for _, row := range rows {
+		firstsolves[row.SubtaskID] = row
+	}
+	return firstsolves, nil
+}
+
+type FirstSolveDetail struct {
+	TaskName           string    `json:"task_name" db:"task_name"`
+	TaskDisplayName    string    `json:"task_display_name" db:"task_display_name"`
+	SubtaskName        string    `json:"subtask_name" db:"subtask_name"`
+	SubtaskDisplayName string    `json:"subtask_display_name" db:"subtask_display_name"`
+	TeamName           string    `json:"team_name" db:"team_name"`
+	TeamDisplayName    string    `json:"team_display_name" db:"team_display_name"`
+	UserName           string    `json:"user_name" db:"user_name"`
+	UserDisplayName    string    `json:"user_display_name" db:"user_display_name"`
+	SolvedAt           int64     `json:"solved_at" db:"-"`
+	SolvedAtTime       time.Time `json:"-" db:"solved_at"`
+}
+
+// 最初に解いたチームの一覧を解いた順に返す。until がゼロ値でなければ until より前に解いたものに限る
+func getfirstsolves(ctx context.Context, q sqlx.QueryerContext, until time.Time) ([]FirstSolveDetail, error) {
+	firstsolves := []FirstSolveDetail{}
+	if err := sqlx.SelectContext(ctx, q, &firstsolves, "SELECT tasks.name AS task_name, tasks.display_name AS task_display_name,"+
+		" subtasks.name AS subtask_name, subtasks.display_name AS subtask_display_name,"+
+		" teams.name AS team_name, teams.display_name AS team_display_name,"+
+		" users.name AS user_name, users.display_name AS user_display_name, first_solves.solved_at"+
+		" FROM first_solves"+
+		" JOIN subtasks ON subtasks.id = first_solves.subtask_id"+
+		" JOIN tasks ON tasks.id = subtasks.task_id"+
+		" JOIN teams ON teams.id = first_solves.team_id"+
+		" JOIN users ON users.id = first_solves.user_id"+
+		beforecondition("first_solves.solved_at", until)+
+		" ORDER BY first_solves.solved_at, first_solves.submission_id", beforeparams(until)...); err != nil {
+		return nil, err
+	}
+	for i := range firstsolves {
+		firstsolves[i].SolvedAt = firstsolves[i].SolvedAtTime.Unix()
+	}
+	return firstsolves, nil
+}
+
+// GET /api/firstsolves
+// 小課題を最初に解いたチームの一覧。凍結中は凍結時刻より前のものに限る
+func getFirstSolvesHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	until := time.Time{}
+	if verifyAdminSession(c) != nil {
+		var err error
+		until, err = standingsengine.publicuntil(ctx, time.Now())
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get standings: "+err.Error())
+		}
+	}
+
+	firstsolves, err := getfirstsolves(ctx, dbConn, until)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get first solves: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, firstsolves)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// 凍結を解除したチーム
+func getrevealedteams(ctx context.Context, q sqlx.QueryerContext) (map[int]bool, error) {
+	teamIDs := []int{}
+	if err := sqlx.SelectContext(ctx, q, &teamIDs, "SELECT team_id FROM standings_reveals"); err != nil {
+		return nil, err
+	}
+	revealed := map[int]bool{}
+	for _, teamID := range teamIDs {
+		revealed[teamID] = true
+	}
+	return revealed, nil
+}
+
+const (
+	unfreezeall  = "all"  // すべてのチームを一度に解除する
+	unfreezestep = "step" // 凍結中の順位表で最下位のチームから 1 チームずつ解除する
+)
+
+type UnfreezeRequest struct {
+	Mode string `json:"mode"`
+}
+type UnfreezeResponse struct {
+	// step で解除したチーム。解除するチームが残っていない場合は空
+	TeamName     string    `json:"team_name,omitempty"`
+	PreviousRank int       `json:"previous_rank,omitempty"`
+	Rank         int       `json:"rank,omitempty"`
+	Remaining    int       `json:"remaining"` // まだ凍結されているチームの数
+	Standings    Standings `json:"standings"`
+}
+
+// POST /api/admin/standings/unfreeze
+// 凍結した順位表を、すべて一度に、または表彰式向けに 1 チームずつ公開する
+func unfreezeStandingsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyAdminSession(c); err != nil {
+		return err
+	}
+
+	req := UnfreezeRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if req.Mode == "" {
+		req.Mode = unfreezeall
+	}
+	if req.Mode != unfreezeall && req.Mode != unfreezestep {
+		return echo.NewHTTPError(http.StatusBadRequest, "mode must be all or step")
+	}
+
+	now := time.Now()
+	if !isscoreboardfrozen(now) {
+		return echo.NewHTTPError(http.StatusBadRequest, "standings are not frozen")
+	}
+
+	before, err := standingsengine.getpublic(ctx, now, false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get standings: "+err.Error())
+	}
+
+	teams := []Team{}
+	if err := dbConn.SelectContext(ctx, &teams, "SELECT * FROM teams"); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get teams: "+err.Error())
+	}
+	teamIDs := map[string]int{}
+	for _, team := range teams {
+		teamIDs[team.Name] = team.ID
+	}
+	revealed, err := getrevealedteams(ctx, dbConn)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get revealed teams: "+err.Error())
+	}
+
+	res := UnfreezeResponse{}
+	targets := []int{}
+	// 凍結中の順位表の下から順に見る
+	for i := len(before.StandingsData) - 1; i >= 0; i-- {
+		row := before.StandingsData[i]
+		teamID, ok := teamIDs[row.TeamName]
+		if !ok || revealed[teamID] {
+			continue
+		}
+		targets = append(targets, teamID)
+		if req.Mode == unfreezestep {
+			res.TeamName = row.TeamName
+			res.PreviousRank = row.Rank
+			break
+		}
+	}
+
+	for _, teamID := range targets {
+		if _, err := dbConn.ExecContext(ctx, "INSERT IGNORE INTO standings_reveals (team_id, revealed_at) VALUES (?, ?)", teamID, now); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert reveal: "+err.Error())
+		}
+		revealed[teamID] = true
+	}
+	standingsengine.reveal(targets)
+
+	after, err := standingsengine.getpublic(ctx, now, false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get standings: "+err.Error())
+	}
+	for _, row := range after.StandingsData {
+		if row.TeamName == res.TeamName {
+			res.Rank = row.Rank
+		}
+		if teamID, ok := teamIDs[row.TeamName]; ok && !revealed[teamID] {
+			res.Remaining++
+		}
+	}
+	res.Standings = *after
+
+	return c.JSON(http.StatusOK, res)
+}
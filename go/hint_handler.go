@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+type Hint struct {
+	ID        int    `db:"id"`
+	TaskID    int    `db:"task_id"`
+	SubtaskID int    `db:"subtask_id"`
+	Position  int    `db:"position"`
+	Content   string `db:"content"`
+	Cost      int    `db:"cost"`
+}
+
+type HintRequest struct {
+	Content string `json:"content"`
+	Cost    int    `json:"cost"`
+}
+
+// 小課題の末尾にヒントを追加する
+func inserthint(ctx context.Context, tx *sqlx.Tx, taskID int, subtaskID int, hint HintRequest) (int, error) {
+	position := 0
+	if err := tx.GetContext(ctx, &position, "SELECT COALESCE(MAX(position), 0) + 1 FROM hints WHERE subtask_id = ?", subtaskID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO hints (task_id, subtask_id, position, content, cost) VALUES (?, ?, ?, ?, ?)", taskID, subtaskID, position, hint.Content, hint.Cost); err != nil {
+		return 0, err
+	}
+	return position, nil
+}
+
+// チームごと・問題ごとのヒントのコストの合計と、チームごとのヒントの使用数を返す
+func gethintcosts(ctx context.Context, q sqlx.QueryerContext) (map[int](map[int]int), map[int]int, error) {
+	type Res struct {
+		TeamID int `db:"team_id"`
+		TaskID int `db:"task_id"`
+		Cost   int `db:"cost"`
+		Count  int `db:"count"`
+	}
+	var results []Res
+	if err := sqlx.SelectContext(ctx, q, &results, "SELECT hint_unlocks.team_id, hints.task_id, SUM(hints.cost) AS cost, COUNT(*) AS count FROM hint_unlocks JOIN hints ON hint_unlocks.hint_id = hints.id GROUP BY hint_unlocks.team_id, hints.task_id"); err != nil {
+		return nil, nil, err
+	}
+
+	costs := map[int](map[int]int){}
+	counts := map[int]int{}
+	for _, r := range results {
+		if _, ok := costs[r.TeamID]; !ok {
+			costs[r.TeamID] = map[int]int{}
+		}
+		costs[r.TeamID][r.TaskID] = r.Cost
+		counts[r.TeamID] += r.Count
+	}
+	return costs, counts, nil
+}
+
+// チームが問題のヒントに使ったコストの合計を返す
+func gettaskhintcost(ctx context.Context, q sqlx.QueryerContext, teamID int, taskID int) (int, error) {
+	cost := 0
+	err := sqlx.GetContext(ctx, q, &cost, "SELECT COALESCE(SUM(hints.cost), 0) FROM hint_unlocks JOIN hints ON hint_unlocks.hint_id = hints.id WHERE hint_unlocks.team_id = ? AND hints.task_id = ?", teamID, taskID)
+	return cost, err
+}
+
+type CreateHintRequest struct {
+	SubtaskName string `json:"subtask_name"`
+	Content     string `json:"content"`
+	Cost        int    `json:"cost"`
+}
+type CreateHintResponse struct {
+	SubtaskName string `json:"subtask_name"`
+	Position    int    `json:"position"`
+}
+
+// POST /api/admin/tasks/:taskname/hints
+func createHintHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyAdminSession(c); err != nil {
+		return err
+	}
+
+	req := CreateHintRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if req.Content == "" || req.Cost < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	task := Task{}
+	err = tx.GetContext(ctx, &task, "SELECT * FROM tasks WHERE name = ?", c.Param("taskname"))
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "task not found")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get task: "+err.Error())
+	}
+
+	// 順番を採番するので、小課題の行をロックしておく
+	subtask := Subtask{}
+	err = tx.GetContext(ctx, &subtask, "SELECT * FROM subtasks WHERE task_id = ? AND name = ? FOR UPDATE", task.ID, req.SubtaskName)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusBadRequest, "subtask not found")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get subtask: "+err.Error())
+	}
+
+	position, err := inserthint(ctx, tx, task.ID, subtask.ID, HintRequest{Content: req.Content, Cost: req.Cost})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert hint: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit transaction: "+err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, CreateHintResponse{
+		SubtaskName: subtask.Name,
+		Position:    position,
+	})
+}
+
+type HintDetail struct {
+	SubtaskName        string `json:"subtask_name"`
+	SubtaskDisplayName string `json:"subtask_display_name"`
+	Position           int    `json:"position"`
+	Cost               int    `json:"cost"`
+	Unlocked           bool   `json:"unlocked"`
+	UnlockedAt         int64  `json:"unlocked_at,omitempty"`
+	Content            string `json:"content,omitempty"` // 解放済みの場合のみ
+}
+
+// ログイン中のユーザーが所属するチームを返す。チームに所属していなければ sql.ErrNoRows を返す
+func getsessionteam(ctx context.Context, c echo.Context, q sqlx.QueryerContext) (User, Team, error) {
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	username, _ := sess.Values[defaultSessionUserNameKey].(string)
+
+	user := User{}
+	if err := sqlx.GetContext(ctx, q, &user, "SELECT * FROM users WHERE name = ?", username); err != nil {
+		return User{}, Team{}, err
+	}
+	team := Team{}
+	if err := sqlx.GetContext(ctx, q, &team, "SELECT * FROM teams WHERE leader_id = ? OR member1_id = ? OR member2_id = ?", user.ID, user.ID, user.ID); err != nil {
+		return user, Team{}, err
+	}
+	return user, team, nil
+}
+
+// GET /api/tasks/:taskname/hints
+func getHintsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		return err
+	}
+
+	task := Task{}
+	err := dbConn.GetContext(ctx, &task, "SELECT * FROM tasks WHERE name = ?", c.Param("taskname"))
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "task not found")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get task: "+err.Error())
+	}
+
+	// チームに所属していない場合は、すべて未解放として返す
+	_, team, err := getsessionteam(ctx, c, dbConn)
+	if err != nil && err != sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get team: "+err.Error())
+	}
+
+	type Res struct {
+		Hint
+		SubtaskName        string       `db:"subtask_name"`
+		SubtaskDisplayName string       `db:"subtask_display_name"`
+		UnlockedAt         sql.NullTime `db:"unlocked_at"`
+	}
+	var hints []Res
+	if err := dbConn.SelectContext(ctx, &hints, "SELECT hints.*, subtasks.name AS subtask_name, subtasks.display_name AS subtask_display_name, hint_unlocks.unlocked_at FROM hints JOIN subtasks ON hints.subtask_id = subtasks.id LEFT JOIN hint_unlocks ON hint_unlocks.hint_id = hints.id AND hint_unlocks.team_id = ? WHERE hints.task_id = ? ORDER BY subtasks.id, hints.position", team.ID, task.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get hints: "+err.Error())
+	}
+
+	res := []HintDetail{}
+	for _, hint := range hints {
+		hintdetail := HintDetail{
+			SubtaskName:        hint.SubtaskName,
+			SubtaskDisplayName: hint.SubtaskDisplayName,
+			Position:           hint.Position,
+			Cost:               hint.Cost,
+		}
+		if hint.UnlockedAt.Valid {
+			hintdetail.Unlocked = true
+			hintdetail.UnlockedAt = hint.UnlockedAt.Time.Unix()
+			hintdetail.Content = hint.Content
+		}
+		res = append(res, hintdetail)
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+type UnlockHintRequest struct {
+	TaskName    string `json:"task_name"`
+	SubtaskName string `json:"subtask_name"`
+	Position    int    `json:"position"`
+}
+
+// POST /api/hints/unlock
+func unlockHintHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		return err
+	}
+
+	req := UnlockHintRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	user, team, err := getsessionteam(ctx, c, tx)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusBadRequest, "you have not joined team")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get team: "+err.Error())
+	}
+
+	task := Task{}
+	err = tx.GetContext(ctx, &task, "SELECT * FROM tasks WHERE name = ?", req.TaskName)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusBadRequest, "task not found")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get task: "+err.Error())
+	}
+
+	subtask := Subtask{}
+	err = tx.GetContext(ctx, &subtask, "SELECT * FROM subtasks WHERE task_id = ? AND name = ?", task.ID, req.SubtaskName)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusBadRequest, "subtask not found")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get subtask: "+err.Error())
+	}
+
+	hint := Hint{}
+	err = tx.GetContext(ctx, &hint, "SELECT * FROM hints WHERE subtask_id = ? AND position = ?", subtask.ID, req.Position)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusBadRequest, "hint not found")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get hint: "+err.Error())
+	}
+
+	res := HintDetail{
+		SubtaskName:        subtask.Name,
+		SubtaskDisplayName: subtask.DisplayName,
+		Position:           hint.Position,
+		Cost:               hint.Cost,
+		Unlocked:           true,
+		Content:            hint.Content,
+	}
+
+	// すでに解放済みであれば、コストを払わずにそのまま返す
+	unlockedat := time.Time{}
+	err = tx.GetContext(ctx, &unlockedat, "SELECT unlocked_at FROM hint_unlocks WHERE team_id = ? AND hint_id = ?", team.ID, hint.ID)
+	if err == nil {
+		res.UnlockedAt = unlockedat.Unix()
+		return c.JSON(http.StatusOK, res)
+	} else if err != sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get hint unlock: "+err.Error())
+	}
+
+	// ヒントは前から順番に解放する
+	lockedcount := 0
+	if err := tx.GetContext(ctx, &lockedcount, "SELECT COUNT(*) FROM hints LEFT JOIN hint_unlocks ON hint_unlocks.hint_id = hints.id AND hint_unlocks.team_id = ? WHERE hints.subtask_id = ? AND hints.position < ? AND hint_unlocks.id IS NULL", team.ID, subtask.ID, hint.Position); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get hint unlocks: "+err.Error())
+	}
+	if lockedcount > 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "previous hints are locked")
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, "INSERT INTO hint_unlocks (team_id, hint_id, user_id, unlocked_at) VALUES (?, ?, ?, ?)", team.ID, hint.ID, user.ID, now)
+	if isduplicatekeyerror(err) {
+		// 同じチームの別のリクエストが先に解放した場合は、そちらの解放を返す
+		// トランザクション内の読み取りでは先に解放した行が見えないので、トランザクションの外から読む
+		tx.Rollback()
+		if err := dbConn.GetContext(ctx, &unlockedat, "SELECT unlocked_at FROM hint_unlocks WHERE team_id = ? AND hint_id = ?", team.ID, hint.ID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get hint unlock: "+err.Error())
+		}
+		res.UnlockedAt = unlockedat.Unix()
+		return c.JSON(http.StatusOK, res)
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert hint unlock: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit transaction: "+err.Error())
+	}
+
+	standingsengine.applyhintunlock(team.ID, hint, now)
+
+	res.UnlockedAt = now.Unix()
+	return c.JSON(http.StatusCreated, res)
+}
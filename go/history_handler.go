@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// 提出とヒントの解放を時刻順にたどり、順位表を作り直す
+// until がゼロ値でなければ、until より前のものだけをたどる
+// onchange は順位表が変わるたびに呼ばれる
+func replaystandings(ctx context.Context, q sqlx.QueryerContext, until time.Time, onchange func(at time.Time, s *standingsstate)) (*standingsstate, error) {
+	s, err := loadstandingsbase(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	type Unlock struct {
+		TeamID     int       `db:"team_id"`
+		HintID     int       `db:"hint_id"`
+		UnlockedAt time.Time `db:"unlocked_at"`
+	}
+	var unlocks []Unlock
+	if err := sqlx.SelectContext(ctx, q, &unlocks, "SELECT team_id, hint_id, unlocked_at FROM hint_unlocks"+beforecondition("unlocked_at", until)+" ORDER BY unlocked_at, id", beforeparams(until)...); err != nil {
+		return nil, err
+	}
+
+	// 提出は多いので、1 件ずつ読みながらヒントの解放と合わせて時刻順に反映する
+	rows, err := q.QueryxContext(ctx, "SELECT id, task_id, user_id, target_subtask_id, subtask_id, score, verdict, submitted_at FROM submissions"+beforecondition("submitted_at", until)+" ORDER BY submitted_at, id", beforeparams(until)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applyunlocksbefore := func(at time.Time) {
+		for len(unlocks) > 0 && (at.IsZero() || unlocks[0].UnlockedAt.Before(at)) {
+			unlock := unlocks[0]
+			unlocks = unlocks[1:]
+			if s.applyhintunlock(unlock.TeamID, s.hints[unlock.HintID]) && onchange != nil {
+				onchange(unlock.UnlockedAt, s)
+			}
+		}
+	}
+
+	for rows.Next() {
+		submission := Submission{}
+		if err := rows.StructScan(&submission); err != nil {
+			return nil, err
+		}
+		applyunlocksbefore(submission.SubmittedAt)
+		if s.applysubmission(submission) && onchange != nil {
+			onchange(submission.SubmittedAt, s)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	applyunlocksbefore(time.Time{})
+
+	return s, nil
+}
+
+type ScorePoint struct {
+	At         int64 `json:"at"`
+	TotalScore int   `json:"total_score"`
+}
+type TeamScoreHistory struct {
+	TeamName        string       `json:"team_name"`
+	TeamDisplayName string       `json:"team_display_name"`
+	Points          []ScorePoint `json:"points"`
+}
+
+// すべてのチームの合計得点の推移。合計得点が変わった時刻ごとに 1 点を返す
+func getscorehistory(ctx context.Context, q sqlx.QueryerContext, until time.Time) ([]TeamScoreHistory, error) {
+	teams := []Team{}
+	if err := sqlx.SelectContext(ctx, q, &teams, "SELECT * FROM teams ORDER BY name"); err != nil {
+		return nil, err
+	}
+	histories := map[int]*TeamScoreHistory{}
+	teamIDs := []int{}
+	for _, team := range teams {
+		teamIDs = append(teamIDs, team.ID)
+		histories[team.ID] = &TeamScoreHistory{TeamName: team.Name, TeamDisplayName: team.DisplayName, Points: []ScorePoint{}}
+	}
+
+	_, err := replaystandings(ctx, q, until, func(at time.Time, s *standingsstate) {
+		for _, teamID := range teamIDs {
+			history := histories[teamID]
+			total := s.rows[teamID].TotalScore
+			points := history.Points
+			if len(points) > 0 && points[len(points)-1].TotalScore == total {
+				continue
+			}
+			if len(points) == 0 && total == 0 {
+				continue
+			}
+			// 同じ時刻の変化はまとめる
+			if len(points) > 0 && points[len(points)-1].At == at.Unix() {
+				points[len(points)-1].TotalScore = total
+				continue
+			}
+			history.Points = append(points, ScorePoint{At: at.Unix(), TotalScore: total})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := []TeamScoreHistory{}
+	for _, teamID := range teamIDs {
+		res = append(res, *histories[teamID])
+	}
+	return res, nil
+}
+
+// GET /api/standings/history
+// チームごとの合計得点の推移を返す。teams にカンマ区切りでチーム名を指定すると、そのチームに限る
+func getStandingsHistoryHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	selected := map[string]bool{}
+	if c.QueryParam("teams") != "" {
+		for _, name := range strings.Split(c.QueryParam("teams"), ",") {
+			selected[name] = true
+		}
+	}
+
+	// 凍結中は凍結時刻までの推移に限る
+	until := time.Time{}
+	if verifyAdminSession(c) != nil {
+		var err error
+		until, err = standingsengine.publicuntil(ctx, time.Now())
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get standings: "+err.Error())
+		}
+	}
+
+	histories, err := replaycache.scorehistory(ctx, until)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get score history: "+err.Error())
+	}
+
+	res := []TeamScoreHistory{}
+	for _, history := range histories {
+		if len(selected) == 0 || selected[history.TeamName] {
+			res = append(res, history)
+		}
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+// 誰でも読める得点の推移と過去の順位表は計算が重いので、結果をキャッシュする
+// 判定の遅れや再判定で過去の結果も変わりうるので、順位表が変わるたびに捨てる
+type replaycacheT struct {
+	mu         sync.Mutex
+	generation int // 捨てるたびに増やす。計算中に捨てられた結果は保存しない
+	histories  map[time.Time][]TeamScoreHistory
+	standings  map[time.Time]*Standings
+
+	// 重い計算が同時にいくつも走らないよう、計算は 1 つずつ行う
+	computing sync.Mutex
+}
+
+// 過去の順位表は任意の時刻を指定できるので、保持する数に上限を設ける
+const maxcachedstandings = 64
+
+var replaycache = &replaycacheT{}
+
+func (r *replaycacheT) clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.generation++
+	r.histories, r.standings = nil, nil
+}
+
+// until より前の得点の推移。until がゼロ値なら現在までのもの
+func (r *replaycacheT) scorehistory(ctx context.Context, until time.Time) ([]TeamScoreHistory, error) {
+	load := func() ([]TeamScoreHistory, bool) {
+		histories, ok := r.histories[until]
+		return histories, ok
+	}
+	store := func(histories []TeamScoreHistory) {
+		if r.histories == nil {
+			r.histories = map[time.Time][]TeamScoreHistory{}
+		}
+		r.histories[until] = histories
+	}
+	return cachedcompute(r, load, store, func() ([]TeamScoreHistory, error) {
+		return getscorehistory(ctx, dbConn, until)
+	})
+}
+
+// at より前の提出とヒントの解放から作った順位表
+func (r *replaycacheT) standingsat(ctx context.Context, at time.Time) (*Standings, error) {
+	load := func() (*Standings, bool) {
+		standings, ok := r.standings[at]
+		return standings, ok
+	}
+	store := func(standings *Standings) {
+		if r.standings == nil || len(r.standings) >= maxcachedstandings {
+			r.standings = map[time.Time]*Standings{}
+		}
+		r.standings[at] = standings
+	}
+	return cachedcompute(r, load, store, func() (*Standings, error) {
+		return getstandings(ctx, at)
+	})
+}
+
+// キャッシュになければ計算して保存する。load と store は r.mu を取った状態で呼ぶ
+func cachedcompute[T any](r *replaycacheT, load func() (T, bool), store func(T), compute func() (T, error)) (T, error) {
+	r.mu.Lock()
+	if value, ok := load(); ok {
+		r.mu.Unlock()
+		return value, nil
+	}
+	r.mu.Unlock()
+
+	r.computing.Lock()
+	defer r.computing.Unlock()
+
+	// 待っている間に他のリクエストが計算した場合
+	r.mu.Lock()
+	if value, ok := load(); ok {
+		r.mu.Unlock()
+		return value, nil
+	}
+	generation := r.generation
+	r.mu.Unlock()
+
+	value, err := compute()
+	if err != nil {
+		return value, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.generation == generation {
+		store(value)
+	}
+	return value, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+const idempotencykeyheader = "Idempotency-Key"
+
+// 同じキーでの再送を同じ提出として扱う期間
+var idempotencykeyretention = time.Duration(getEnvInt("RISUCON_IDEMPOTENCY_KEY_RETENTION_MINUTES", 1440)) * time.Minute
+
+var errIdempotencyKeyReused = errors.New("idempotency key is already used for a different request")
+
+type IdempotencyKey struct {
+	ID        int            `db:"id"`
+	UserID    int            `db:"user_id"`
+	Key       string         `db:"idem_key"`
+	Request   string         `db:"request"`
+	Status    int            `db:"status"`
+	Response  sql.NullString `db:"response"`
+	CreatedAt time.Time      `db:"created_at"`
+}
+
+// MySQL の重複キーのエラーかどうか
+func isduplicatekeyerror(err error) bool {
+	var mysqlerr *mysql.MySQLError
+	return errors.As(err, &mysqlerr) && mysqlerr.Number == 1062
+}
+
+// キーをトランザクション内で確保する。確保できた場合は true を返す
+// 同じキーを使う別のトランザクションが実行中の場合は、一意制約によってその完了を待つことになる
+func claimidempotencykey(ctx context.Context, tx *sqlx.Tx, userID int, key string, request string, now time.Time) (bool, error) {
+	// 保持期間を過ぎたキーは新しいキーとして扱う
+	if _, err := tx.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE user_id = ? AND idem_key = ? AND created_at < ?", userID, key, now.Add(-idempotencykeyretention)); err != nil {
+		return false, err
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO idempotency_keys (user_id, idem_key, request, created_at) VALUES (?, ?, ?, ?)", userID, key, request, now)
+	if isduplicatekeyerror(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 確保したキーに、返したレスポンスを記録する
+func saveidempotentresponse(ctx context.Context, tx *sqlx.Tx, userID int, key string, status int, res interface{}) error {
+	body, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE idempotency_keys SET status = ?, response = ? WHERE user_id = ? AND idem_key = ?", status, string(body), userID, key)
+	return err
+}
+
+// 記録済みのレスポンスを取得する。リクエストの内容が異なる場合は errIdempotencyKeyReused を返す
+func getidempotentresponse(ctx context.Context, q sqlx.QueryerContext, userID int, key string, request string) (IdempotencyKey, error) {
+	idem := IdempotencyKey{}
+	if err := sqlx.GetContext(ctx, q, &idem, "SELECT * FROM idempotency_keys WHERE user_id = ? AND idem_key = ?", userID, key); err != nil {
+		return IdempotencyKey{}, err
+	}
+	if idem.Request != request {
+		return IdempotencyKey{}, errIdempotencyKeyReused
+	}
+	return idem, nil
+}
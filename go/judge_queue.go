@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	// 1 の場合、提出は判定待ちとして記録し、判定はワーカーがバックグラウンドで行う
+	asyncjudging = getEnv("RISUCON_ASYNC_JUDGING", "0") == "1"
+	judgeworkers = getEnvInt("RISUCON_JUDGE_WORKERS", 4)
+	// 判定待ちの提出を DB から拾い直す間隔。再起動時やキューが溢れたときの取りこぼしを防ぐ
+	judgesweepinterval = time.Duration(getEnvInt("RISUCON_JUDGE_SWEEP_INTERVAL_SEC", 10)) * time.Second
+
+	judgequeue = make(chan int, 1024)
+	// キューに積まれている提出の ID。同じ提出を何度も積まないようにする
+	queuedsubmissions = sync.Map{}
+)
+
+// 提出を判定のキューに積む。すでに積まれている場合やキューがいっぱいの場合は何もしない
+func enqueuejudge(submissionID int) {
+	if _, loaded := queuedsubmissions.LoadOrStore(submissionID, struct{}{}); loaded {
+		return
+	}
+	select {
+	case judgequeue <- submissionID:
+	default:
+		// 次の見回りで改めて積む
+		queuedsubmissions.Delete(submissionID)
+	}
+}
+
+// 判定待ちの提出をすべてキューに積む
+func enqueuependingsubmissions(ctx context.Context) error {
+	submissionIDs := []int{}
+	if err := dbConn.SelectContext(ctx, &submissionIDs, "SELECT id FROM submissions WHERE verdict = ? ORDER BY id", verdictpending); err != nil {
+		return err
+	}
+	for _, submissionID := range submissionIDs {
+		enqueuejudge(submissionID)
+	}
+	return nil
+}
+
+// 判定のワーカーと、判定待ちの提出を拾い直す見回りを起動する
+func startjudgeworkers(ctx context.Context, logger echo.Logger) {
+	for i := 0; i < judgeworkers; i++ {
+		go func() {
+			for submissionID := range judgequeue {
+				if err := judgesubmission(ctx, submissionID); err != nil {
+					// 記録に失敗した場合は判定待ちのまま残るので、次の見回りで再び判定する
+					// 判定自体に失敗した場合は、判定の失敗として記録されている
+					logger.Errorf("failed to judge submission %d: %v", submissionID, err)
+				}
+				queuedsubmissions.Delete(submissionID)
+			}
+		}()
+	}
+
+	go func() {
+		ticker := time.NewTicker(judgesweepinterval)
+		defer ticker.Stop()
+		for {
+			if err := enqueuependingsubmissions(ctx); err != nil {
+				logger.Errorf("failed to enqueue pending submissions: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// 判定待ちの提出を判定し、結果と得点を記録する
+func judgesubmission(ctx context.Context, submissionID int) error {
+	submission := Submission{}
+	err := dbConn.GetContext(ctx, &submission, "SELECT * FROM submissions WHERE id = ?", submissionID)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// 他のワーカーや再判定ですでに判定済み
+	if submission.Verdict != verdictpending {
+		return nil
+	}
+
+	task := Task{}
+	if err := dbConn.GetContext(ctx, &task, "SELECT * FROM tasks WHERE id = ?", submission.TaskID); err != nil {
+		return err
+	}
+	team := Team{}
+	if err := dbConn.GetContext(ctx, &team, "SELECT * FROM teams WHERE leader_id = ? OR member1_id = ? OR member2_id = ?", submission.UserID, submission.UserID, submission.UserID); err != nil {
+		return err
+	}
+
+	// 外部の checker は時間がかかることがあるので、トランザクションの外で判定する
+	result, judgeerr := judgeanswer(ctx, dbConn, task, submission.TargetSubtaskID, submission.Answer, submission.SubmittedAt)
+	if judgeerr != nil {
+		// 停止する場合は判定待ちのまま残し、次の起動時に判定する
+		if ctx.Err() != nil {
+			return judgeerr
+		}
+		// 見回りで何度も拾い直さないよう、判定の失敗として記録する。再判定で直せる
+		result = JudgeResult{Verdict: verdictchecker}
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 同じチームの提出の記録は順番に行う。ロックするのはチームの行だけにする
+	lockedteamID := 0
+	if err := tx.GetContext(ctx, &lockedteamID, "SELECT id FROM teams WHERE id = ? FOR UPDATE", team.ID); err != nil {
+		return err
+	}
+
+	// 提出は記録済みなので、この提出より前の提出だけを数える
+	result, err = applyattemptlimit(ctx, tx, result, submission.TargetSubtaskID, team, submission.ID)
+	if err != nil {
+		return err
+	}
+
+	subtaskID := nullsubtaskid
+	if result.IsScored {
+		subtaskID = result.Subtask.ID
+	}
+	updateres, err := tx.ExecContext(ctx, "UPDATE submissions SET subtask_id = ?, score = ?, verdict = ? WHERE id = ? AND verdict = ?", subtaskID, result.Score, result.Verdict, submission.ID, verdictpending)
+	if err != nil {
+		return err
+	}
+	// 判定している間に、他のワーカーや管理者が判定待ちでなくした
+	if affected, err := updateres.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return nil
+	}
+	if result.IsScored {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO subtask_scores_of_user (user_id, subtask_id, score) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE score = GREATEST(score, ?)", submission.UserID, result.Subtask.ID, result.Score, result.Score); err != nil {
+			return err
+		}
+		if result.Verdict == verdictaccepted {
+			if err := insertsubtasksolve(ctx, tx, result.Subtask.ID, team.ID, submission.SubmittedAt); err != nil {
+				return err
+			}
+			if _, err := insertfirstsolve(ctx, tx, result.Subtask.ID, team.ID, submission.UserID, submission.ID, submission.SubmittedAt); err != nil {
+				return err
+			}
+		}
+	}
+	if len(result.CheckerErrors) > 0 {
+		if err := insertcheckerfailures(ctx, tx, submission.ID, task.ID, result.CheckerErrors, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	submission.SubtaskID, submission.Score, submission.Verdict = subtaskID, result.Score, result.Verdict
+	standingsengine.applysubmission(submission)
+	return judgeerr
+}
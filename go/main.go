@@ -4,6 +4,7 @@ package main
 
 import (
 	// "fmt"
+	"context"
 	"log"
 	"net"
 	"net/http"
@@ -50,6 +51,15 @@ func getEnv(key string, defaultValue string) string {
 	return defaultValue
 }
 
+// 環境変数を正の整数として取得する、なければデフォルト値を返す
+func getEnvInt(key string, defaultValue int) int {
+	val, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil || val <= 0 {
+		return defaultValue
+	}
+	return val
+}
+
 // DBに接続する
 func connectDB() (*sqlx.DB, error) {
 	config := mysql.NewConfig()
@@ -72,6 +82,9 @@ func initializeHandler(c echo.Context) error {
 
 	// キャッシュを消す
 	subtaskcache = sync.Map{}
+	if err := standingsengine.reload(c.Request().Context()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to load standings: "+err.Error())
+	}
 
 	c.Request().Header.Add("Content-Type", "application/json;charset=utf-8")
 	return c.JSON(http.StatusOK, InitializeResponse{
@@ -112,12 +125,34 @@ func main() {
 	// contest
 	e.GET("/api/tasks", getTasksHandler)
 	e.GET("/api/standings", getStandingsHandler)
+	e.GET("/api/standings/history", getStandingsHistoryHandler)
+	e.GET("/api/standings/users", getUserStandingsHandler)
+	e.GET("/api/firstsolves", getFirstSolvesHandler)
 	e.GET("/api/tasks/:taskname", getTaskHandler)
 	e.POST("/api/submit", submitHandler)
 	e.GET("/api/submissions", getSubmissionsHandler)
+	e.GET("/api/submissions/:id", getSubmissionHandler)
+	e.GET("/api/tasks/:taskname/revisions", getRevisionsHandler)
+	e.GET("/api/tasks/:taskname/diff", getStatementDiffHandler)
+	e.GET("/api/revisions", getRevisionNoticesHandler)
+	e.GET("/api/tasks/:taskname/hints", getHintsHandler)
+	e.POST("/api/hints/unlock", unlockHintHandler)
+	e.GET("/api/time", getServerTimeHandler)
 
 	// for admin
 	e.POST("/api/admin/createtask", createTaskHandler)
+	e.POST("/api/admin/tasks/:taskname/statement", updateStatementHandler)
+	e.POST("/api/admin/tasks/:taskname/hints", createHintHandler)
+	e.POST("/api/admin/tasks/:taskname/check", checkAnswerHandler)
+	e.GET("/api/admin/clockskew", getClockSkewHandler)
+	e.POST("/api/admin/rejudge", rejudgeHandler)
+	e.POST("/api/admin/submissions/:id/invalidate", invalidateSubmissionHandler)
+	e.GET("/api/admin/checkerfailures", getCheckerFailuresHandler)
+	e.GET("/api/admin/submissions/export", exportSubmissionsHandler)
+	e.GET("/api/admin/standings/verify", verifyStandingsHandler)
+	e.POST("/api/admin/standings/unfreeze", unfreezeStandingsHandler)
+	e.GET("/api/admin/standings/export", exportStandingsHandler)
+	e.GET("/api/admin/events", getEventFeedHandler)
 
 	// 静的ファイル
 	e.Static("/assets", frontendContentsPath+"/assets")
@@ -125,6 +160,11 @@ func main() {
 	// 以上に当てはまらなければ index.html を返す
 	e.GET("/*", getIndexHandler)
 
+	if err := checktiebreakconfig(); err != nil {
+		e.Logger.Errorf("invalid config: %v", err)
+		os.Exit(1)
+	}
+
 	// DB接続
 	db, err := connectDB()
 	if err != nil {
@@ -139,6 +179,15 @@ func main() {
 	}
 	dbConn = db
 
+	// 失敗しても最初の読み出しで読み込み直す
+	if err := standingsengine.reload(context.Background()); err != nil {
+		e.Logger.Errorf("failed to load standings: %v", err)
+	}
+
+	if asyncjudging {
+		startjudgeworkers(context.Background(), e.Logger)
+	}
+
 	// サーバー起動
 	listenAddr := net.JoinHostPort("", strconv.Itoa(listenPort))
 	e.Logger.Infof("listening on %s", listenAddr)
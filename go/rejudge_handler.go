@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// 再判定・得点の再計算の対象
+type rejudgescope struct {
+	taskID    int   // 0 の場合はすべての問題
+	subtaskID int   // 0 の場合はすべての小課題
+	userIDs   []int // nil の場合はすべてのユーザー
+}
+
+// 対象の提出を絞り込む条件を返す。小課題の絞り込みは含まない
+func (scope rejudgescope) submissionconditions() ([]string, []interface{}, error) {
+	// 判定待ちの提出はワーカーに任せる。制限超過の提出も、答えや制限の変更に合わせて判定し直す
+	conditions := []string{"verdict NOT IN (?, ?)"}
+	params := []interface{}{verdictinvalid, verdictpending}
+	if scope.taskID != 0 {
+		conditions = append(conditions, "task_id = ?")
+		params = append(params, scope.taskID)
+	}
+	if scope.userIDs != nil {
+		query, args, err := sqlx.In("user_id IN (?)", scope.userIDs)
+		if err != nil {
+			return nil, nil, err
+		}
+		conditions = append(conditions, query)
+		params = append(params, args...)
+	}
+	return conditions, params, nil
+}
+
+// チームごとの合計得点を計算する
+func getteamtotalscores(ctx context.Context, q sqlx.QueryerContext) (map[int]int, error) {
+	teams := []Team{}
+	if err := sqlx.SelectContext(ctx, q, &teams, "SELECT * FROM teams"); err != nil {
+		return nil, err
+	}
+
+	type Res struct {
+		UserID    int `db:"user_id"`
+		SubtaskID int `db:"subtask_id"`
+		Score     int `db:"score"`
+	}
+	var subtask_scores []Res
+	if err := sqlx.SelectContext(ctx, q, &subtask_scores, "SELECT user_id, subtask_id, score FROM subtask_scores_of_user"); err != nil {
+		return nil, err
+	}
+	scores := map[int](map[int]int){}
+	for _, subtask_score := range subtask_scores {
+		if _, ok := scores[subtask_score.UserID]; !ok {
+			scores[subtask_score.UserID] = map[int]int{}
+		}
+		scores[subtask_score.UserID][subtask_score.SubtaskID] = subtask_score.Score
+	}
+
+	// 動的配点の小課題は現在の配点に換算する
+	tasks := []Task{}
+	if err := sqlx.SelectContext(ctx, q, &tasks, "SELECT * FROM tasks"); err != nil {
+		return nil, err
+	}
+	subtasks := []Subtask{}
+	if err := sqlx.SelectContext(ctx, q, &subtasks, "SELECT * FROM subtasks"); err != nil {
+		return nil, err
+	}
+	dynamicvalues, err := getdynamicvalues(ctx, q, tasks, subtasks)
+	if err != nil {
+		return nil, err
+	}
+	if len(dynamicvalues) > 0 {
+		basemaxscores := map[int]int{}
+		for _, subtask := range subtasks {
+			if basemaxscores[subtask.ID], err = getsubtaskmaxscore(ctx, q, subtask); err != nil {
+				return nil, err
+			}
+		}
+		scaledynamicscores(scores, basemaxscores, dynamicvalues)
+	}
+
+	hintcosts, _, err := gethintcosts(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	taskofsubtask := map[int]int{}
+	for _, subtask := range subtasks {
+		taskofsubtask[subtask.ID] = subtask.TaskID
+	}
+
+	totals := map[int]int{}
+	for _, team := range teams {
+		// 小課題ごとにメンバーの最高得点を取る
+		teamscores := map[int]int{}
+		for _, userID := range teammemberids(team) {
+			for subtaskID, score := range scores[userID] {
+				teamscores[subtaskID] = max(teamscores[subtaskID], score)
+			}
+		}
+		// ヒントのコストは問題ごとに引き、問題の得点は 0 未満にはしない
+		taskscores := map[int]int{}
+		for subtaskID, score := range teamscores {
+			taskscores[taskofsubtask[subtaskID]] += score
+		}
+		total := 0
+		for _, task := range tasks {
+			total += max(taskscores[task.ID]-hintcosts[team.ID][task.ID], 0)
+		}
+		totals[team.ID] = total
+	}
+	return totals, nil
+}
+
+// 対象の範囲の subtask_scores_of_user を、記録されている提出から作り直す
+func rebuildsubtaskscores(ctx context.Context, tx *sqlx.Tx, scope rejudgescope) error {
+	conditions := []string{}
+	params := []interface{}{}
+	if scope.subtaskID != 0 {
+		conditions = append(conditions, "subtask_id = ?")
+		params = append(params, scope.subtaskID)
+	} else if scope.taskID != 0 {
+		conditions = append(conditions, "subtask_id IN (SELECT id FROM subtasks WHERE task_id = ?)")
+		params = append(params, scope.taskID)
+	}
+	if scope.userIDs != nil {
+		query, args, err := sqlx.In("user_id IN (?)", scope.userIDs)
+		if err != nil {
+			return err
+		}
+		conditions = append(conditions, query)
+		params = append(params, args...)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " AND " + strings.Join(conditions, " AND ")
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM subtask_scores_of_user WHERE 1 = 1"+where, params...); err != nil {
+		return err
+	}
+	insertparams := append([]interface{}{nullsubtaskid, verdictinvalid}, params...)
+	if _, err := tx.ExecContext(ctx, "INSERT INTO subtask_scores_of_user (user_id, subtask_id, score) SELECT user_id, subtask_id, MAX(score) FROM submissions WHERE subtask_id != ? AND verdict != ?"+where+" GROUP BY user_id, subtask_id", insertparams...); err != nil {
+		return err
+	}
+	// 解いたチーム数が変わると動的配点も変わるので、合わせて作り直す
+	if err := rebuildsubtasksolves(ctx, tx, scope); err != nil {
+		return err
+	}
+	return rebuildfirstsolves(ctx, tx, scope)
+}
+
+type TeamScoreChange struct {
+	TeamName        string `json:"team_name"`
+	TeamDisplayName string `json:"team_display_name"`
+	OldScore        int    `json:"old_score"`
+	NewScore        int    `json:"new_score"`
+}
+type RejudgeResponse struct {
+	RejudgedCount int               `json:"rejudged_count"` // 再判定した提出の数
+	ChangedCount  int               `json:"changed_count"`  // 判定結果が変わった提出の数
+	ChangedTeams  []TeamScoreChange `json:"changed_teams"`
+}
+
+// 得点の変化したチームを列挙する
+func getchangedteams(ctx context.Context, q sqlx.QueryerContext, before map[int]int, after map[int]int) ([]TeamScoreChange, error) {
+	teams := []Team{}
+	if err := sqlx.SelectContext(ctx, q, &teams, "SELECT * FROM teams ORDER BY name"); err != nil {
+		return nil, err
+	}
+	changes := []TeamScoreChange{}
+	for _, team := range teams {
+		if before[team.ID] == after[team.ID] {
+			continue
+		}
+		changes = append(changes, TeamScoreChange{
+			TeamName:        team.Name,
+			TeamDisplayName: team.DisplayName,
+			OldScore:        before[team.ID],
+			NewScore:        after[team.ID],
+		})
+	}
+	return changes, nil
+}
+
+type RejudgeRequest struct {
+	TaskName    string `json:"task_name"`    // 省略するとすべての問題
+	SubtaskName string `json:"subtask_name"` // task_name と一緒に指定する
+	TeamName    string `json:"team_name"`    // 省略するとすべてのチーム
+}
+
+// POST /api/admin/rejudge
+// 記録されている提出を現在の答えで判定し直し、得点を作り直す
+func rejudgeHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyAdminSession(c); err != nil {
+		return err
+	}
+
+	req := RejudgeRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+	if req.SubtaskName != "" && req.TaskName == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "subtask_name requires task_name")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	scope := rejudgescope{}
+	if req.TaskName != "" {
+		task := Task{}
+		err := tx.GetContext(ctx, &task, "SELECT * FROM tasks WHERE name = ?", req.TaskName)
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusBadRequest, "task not found")
+		} else if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get task: "+err.Error())
+		}
+		scope.taskID = task.ID
+	}
+	if req.SubtaskName != "" {
+		subtask := Subtask{}
+		err := tx.GetContext(ctx, &subtask, "SELECT * FROM subtasks WHERE task_id = ? AND name = ?", scope.taskID, req.SubtaskName)
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusBadRequest, "subtask not found")
+		} else if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get subtask: "+err.Error())
+		}
+		scope.subtaskID = subtask.ID
+	}
+	if req.TeamName != "" {
+		team := Team{}
+		err := tx.GetContext(ctx, &team, "SELECT * FROM teams WHERE name = ?", req.TeamName)
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusBadRequest, "team not found")
+		} else if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get team: "+err.Error())
+		}
+		scope.userIDs = teammemberids(team)
+	}
+
+	before, err := getteamtotalscores(ctx, tx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get team scores: "+err.Error())
+	}
+
+	conditions, params, err := scope.submissionconditions()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to build query: "+err.Error())
+	}
+	submissions := []Submission{}
+	if err := tx.SelectContext(ctx, &submissions, "SELECT * FROM submissions WHERE "+strings.Join(conditions, " AND ")+" ORDER BY id FOR UPDATE", params...); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get submissions: "+err.Error())
+	}
+
+	tasks := map[int]Task{}
+	// 同じ問題への同じ答えは同じ判定になるので、結果を使い回す
+	// 得点が減衰する問題では提出時刻によって得点が変わるので、時刻もキーに含める
+	type judgekey struct {
+		targetSubtaskID int
+		answer          string
+		at              int64
+	}
+	results := map[int](map[judgekey]JudgeResult){}
+	teams := map[int]Team{} // ユーザー → チーム
+
+	res := RejudgeResponse{}
+	for _, submission := range submissions {
+		task, ok := tasks[submission.TaskID]
+		if !ok {
+			if err := tx.GetContext(ctx, &task, "SELECT * FROM tasks WHERE id = ?", submission.TaskID); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get task: "+err.Error())
+			}
+			tasks[task.ID] = task
+			results[task.ID] = map[judgekey]JudgeResult{}
+		}
+		key := judgekey{targetSubtaskID: submission.TargetSubtaskID, answer: submission.Answer}
+		if task.DecayType != decaynone {
+			key.at = submission.SubmittedAt.Unix()
+		}
+		result, ok := results[task.ID][key]
+		if !ok {
+			result, err = judgeanswer(ctx, tx, task, submission.TargetSubtaskID, submission.Answer, submission.SubmittedAt)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to judge answer: "+err.Error())
+			}
+			results[task.ID][key] = result
+		}
+
+		// 提出回数の制限は提出の ID 順に数えるので、判定し直した結果にも同じ順で当てはめる
+		if submission.TargetSubtaskID != nullsubtaskid {
+			team, ok := teams[submission.UserID]
+			if !ok {
+				if err := tx.GetContext(ctx, &team, "SELECT * FROM teams WHERE leader_id = ? OR member1_id = ? OR member2_id = ?", submission.UserID, submission.UserID, submission.UserID); err != nil {
+					return echo.NewHTTPError(http.StatusInternalServerError, "failed to get team: "+err.Error())
+				}
+				teams[submission.UserID] = team
+			}
+			result, err = applyattemptlimit(ctx, tx, result, submission.TargetSubtaskID, team, submission.ID)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get attempt count: "+err.Error())
+			}
+		}
+
+		subtaskID := nullsubtaskid
+		if result.IsScored {
+			subtaskID = result.Subtask.ID
+		}
+		// 小課題を指定した場合は、その小課題に関係する提出のみ判定し直す
+		if scope.subtaskID != 0 && submission.TargetSubtaskID != scope.subtaskID && submission.SubtaskID != scope.subtaskID && subtaskID != scope.subtaskID {
+			continue
+		}
+		res.RejudgedCount++
+
+		if len(result.CheckerErrors) > 0 {
+			if err := insertcheckerfailures(ctx, tx, submission.ID, task.ID, result.CheckerErrors, time.Now()); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert checker failures: "+err.Error())
+			}
+		}
+
+		if submission.SubtaskID == subtaskID && submission.Score == result.Score && submission.Verdict == result.Verdict {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE submissions SET subtask_id = ?, score = ?, verdict = ? WHERE id = ?", subtaskID, result.Score, result.Verdict, submission.ID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update submission: "+err.Error())
+		}
+		res.ChangedCount++
+	}
+
+	// 判定し直した提出は別の小課題に移ることがあるので、問題全体の得点を作り直す
+	rebuildscope := rejudgescope{taskID: scope.taskID, userIDs: scope.userIDs}
+	if err := rebuildsubtaskscores(ctx, tx, rebuildscope); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to rebuild subtask scores: "+err.Error())
+	}
+
+	after, err := getteamtotalscores(ctx, tx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get team scores: "+err.Error())
+	}
+	res.ChangedTeams, err = getchangedteams(ctx, tx, before, after)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get teams: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit transaction: "+err.Error())
+	}
+	standingsengine.invalidate()
+
+	return c.JSON(http.StatusOK, res)
+}
+
+// POST /api/admin/submissions/:id/invalidate
+// 提出を無効にし、その提出を除いて得点を作り直す
+func invalidateSubmissionHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyAdminSession(c); err != nil {
+		return err
+	}
+
+	submissionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to parse id: "+err.Error())
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	submission := Submission{}
+	err = tx.GetContext(ctx, &submission, "SELECT * FROM submissions WHERE id = ? FOR UPDATE", submissionID)
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "submission not found")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get submission: "+err.Error())
+	}
+	if submission.Verdict == verdictinvalid {
+		return echo.NewHTTPError(http.StatusBadRequest, "submission is already invalidated")
+	}
+
+	before, err := getteamtotalscores(ctx, tx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get team scores: "+err.Error())
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE submissions SET score = 0, verdict = ? WHERE id = ?", verdictinvalid, submission.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update submission: "+err.Error())
+	}
+
+	res := RejudgeResponse{
+		RejudgedCount: 0,
+		ChangedCount:  1,
+	}
+	if submission.SubtaskID != nullsubtaskid {
+		scope := rejudgescope{
+			taskID:    submission.TaskID,
+			subtaskID: submission.SubtaskID,
+			userIDs:   []int{submission.UserID},
+		}
+		if err := rebuildsubtaskscores(ctx, tx, scope); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to rebuild subtask scores: "+err.Error())
+		}
+	}
+
+	after, err := getteamtotalscores(ctx, tx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get team scores: "+err.Error())
+	}
+	res.ChangedTeams, err = getchangedteams(ctx, tx, before, after)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get teams: "+err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit transaction: "+err.Error())
+	}
+	standingsengine.invalidate()
+
+	return c.JSON(http.StatusOK, res)
+}
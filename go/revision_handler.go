@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+type StatementRevision struct {
+	ID        int       `db:"id"`
+	TaskID    int       `db:"task_id"`
+	SubtaskID int       `db:"subtask_id"`
+	Revision  int       `db:"revision"`
+	Statement string    `db:"statement"`
+	AuthorID  int       `db:"author_id"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// 問題文の改訂を 1 件記録する
+// subtaskID が nullsubtaskid の場合は問題全体の問題文を表す
+func insertstatementrevision(ctx context.Context, tx *sqlx.Tx, taskID int, subtaskID int, revision int, statement string, authorID int, at time.Time) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO statement_revisions (task_id, subtask_id, revision, statement, author_id, created_at) VALUES (?, ?, ?, ?, ?, ?)", taskID, subtaskID, revision, statement, authorID, at)
+	return err
+}
+
+// 問題の現在の改訂番号と最終更新時刻を返す。履歴がなければ 0 を返す
+func getcurrentrevision(ctx context.Context, q sqlx.QueryerContext, taskID int) (int, time.Time, error) {
+	type Res struct {
+		Revision  int       `db:"revision"`
+		CreatedAt time.Time `db:"created_at"`
+	}
+	res := Res{}
+	err := sqlx.GetContext(ctx, q, &res, "SELECT revision, created_at FROM statement_revisions WHERE task_id = ? ORDER BY revision DESC LIMIT 1", taskID)
+	if err == sql.ErrNoRows {
+		return 0, time.Time{}, nil
+	} else if err != nil {
+		return 0, time.Time{}, err
+	}
+	return res.Revision, res.CreatedAt, nil
+}
+
+type SubtaskStatementRequest struct {
+	Name      string `json:"name"`
+	Statement string `json:"statement"`
+}
+type UpdateStatementRequest struct {
+	Statement *string                   `json:"statement,omitempty"` // 省略された場合は問題全体の問題文を変更しない
+	Subtasks  []SubtaskStatementRequest `json:"subtasks"`
+}
+type UpdateStatementResponse struct {
+	Revision  int   `json:"revision"`
+	RevisedAt int64 `json:"revised_at"`
+}
+
+// POST /api/admin/tasks/:taskname/statement
+func updateStatementHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyAdminSession(c); err != nil {
+		return err
+	}
+
+	req := UpdateStatementRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
+	}
+
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	}
+	defer tx.Rollback()
+
+	admin := User{}
+	if err := tx.GetContext(ctx, &admin, "SELECT * FROM users WHERE name = ?", "admin"); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+	}
+
+	// 改訂番号を採番するので、問題の行をロックしておく
+	task := Task{}
+	err = tx.GetContext(ctx, &task, "SELECT * FROM tasks WHERE name = ? FOR UPDATE", c.Param("taskname"))
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "task not found")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get task: "+err.Error())
+	}
+
+	revision, revisedat, err := getcurrentrevision(ctx, tx, task.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get revision: "+err.Error())
+	}
+	newrevision := revision + 1
+	now := time.Now()
+	changed := false
+
+	if req.Statement != nil && *req.Statement != task.Statement {
+		if _, err := tx.ExecContext(ctx, "UPDATE tasks SET statement = ? WHERE id = ?", *req.Statement, task.ID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update task: "+err.Error())
+		}
+		if err := insertstatementrevision(ctx, tx, task.ID, nullsubtaskid, newrevision, *req.Statement, admin.ID, now); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert statement revision: "+err.Error())
+		}
+		changed = true
+	}
+
+	for _, subtaskreq := range req.Subtasks {
+		subtask := Subtask{}
+		err := tx.GetContext(ctx, &subtask, "SELECT * FROM subtasks WHERE task_id = ? AND name = ?", task.ID, subtaskreq.Name)
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusBadRequest, "subtask not found: "+subtaskreq.Name)
+		} else if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get subtask: "+err.Error())
+		}
+		if subtask.Statement == subtaskreq.Statement {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE subtasks SET statement = ? WHERE id = ?", subtaskreq.Statement, subtask.ID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update subtask: "+err.Error())
+		}
+		if err := insertstatementrevision(ctx, tx, task.ID, subtask.ID, newrevision, subtaskreq.Statement, admin.ID, now); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert statement revision: "+err.Error())
+		}
+		changed = true
+	}
+
+	if err := tx.Commit(); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit transaction: "+err.Error())
+	}
+
+	// 変更がなければ改訂番号は進めない
+	if !changed {
+		return c.JSON(http.StatusOK, UpdateStatementResponse{
+			Revision:  revision,
+			RevisedAt: revisedat.Unix(),
+		})
+	}
+
+	// 小課題の問題文が変わったのでキャッシュを消す
+	subtaskcache.Delete(task.ID)
+
+	return c.JSON(http.StatusOK, UpdateStatementResponse{
+		Revision:  newrevision,
+		RevisedAt: now.Unix(),
+	})
+}
+
+type RevisionSummary struct {
+	Revision          int      `json:"revision"`
+	AuthorName        string   `json:"author_name"`
+	AuthorDisplayName string   `json:"author_display_name"`
+	CreatedAt         int64    `json:"created_at"`
+	StatementChanged  bool     `json:"statement_changed"`
+	ChangedSubtasks   []string `json:"changed_subtasks"`
+}
+
+// 問題と、その改訂履歴・小課題の一覧を取得する
+func getrevisions(ctx context.Context, taskname string) (Task, []StatementRevision, map[int]Subtask, error) {
+	task := Task{}
+	if err := dbConn.GetContext(ctx, &task, "SELECT * FROM tasks WHERE name = ?", taskname); err != nil {
+		return Task{}, nil, nil, err
+	}
+
+	revisions := []StatementRevision{}
+	if err := dbConn.SelectContext(ctx, &revisions, "SELECT * FROM statement_revisions WHERE task_id = ? ORDER BY revision, subtask_id", task.ID); err != nil {
+		return Task{}, nil, nil, err
+	}
+
+	subtasks := []Subtask{}
+	if err := dbConn.SelectContext(ctx, &subtasks, "SELECT * FROM subtasks WHERE task_id = ? ORDER BY id", task.ID); err != nil {
+		return Task{}, nil, nil, err
+	}
+	subtasks_map := map[int]Subtask{}
+	for _, subtask := range subtasks {
+		subtasks_map[subtask.ID] = subtask
+	}
+
+	return task, revisions, subtasks_map, nil
+}
+
+// GET /api/tasks/:taskname/revisions
+func getRevisionsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	_, revisions, subtasks_map, err := getrevisions(ctx, c.Param("taskname"))
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "task not found")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get revisions: "+err.Error())
+	}
+
+	var authors []User
+	if err := dbConn.SelectContext(ctx, &authors, "SELECT * FROM users WHERE id IN (SELECT author_id FROM statement_revisions)"); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get users: "+err.Error())
+	}
+	authors_map := map[int]User{}
+	for _, author := range authors {
+		authors_map[author.ID] = author
+	}
+
+	res := []RevisionSummary{}
+	for _, revision := range revisions {
+		if len(res) == 0 || res[len(res)-1].Revision != revision.Revision {
+			author := authors_map[revision.AuthorID]
+			res = append(res, RevisionSummary{
+				Revision:          revision.Revision,
+				AuthorName:        author.Name,
+				AuthorDisplayName: author.DisplayName,
+				CreatedAt:         revision.CreatedAt.Unix(),
+				ChangedSubtasks:   []string{},
+			})
+		}
+		summary := &res[len(res)-1]
+		if revision.SubtaskID == nullsubtaskid {
+			summary.StatementChanged = true
+		} else {
+			summary.ChangedSubtasks = append(summary.ChangedSubtasks, subtasks_map[revision.SubtaskID].Name)
+		}
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+type DiffLine struct {
+	Op   string `json:"op"` // "equal", "insert", "delete" のいずれか
+	Text string `json:"text"`
+}
+type SubtaskDiff struct {
+	Name        string     `json:"name"`
+	DisplayName string     `json:"display_name"`
+	Lines       []DiffLine `json:"lines"`
+}
+type StatementDiffResponse struct {
+	TaskName  string        `json:"task_name"`
+	From      int           `json:"from"`
+	To        int           `json:"to"`
+	Statement []DiffLine    `json:"statement,omitempty"` // 問題全体の問題文に変更がなければ省略
+	Subtasks  []SubtaskDiff `json:"subtasks"`            // 変更のあった小課題のみ
+}
+
+// 指定した改訂の時点での問題文を返す
+func statementat(revisions []StatementRevision, subtaskID int, revision int) string {
+	statement := ""
+	for _, r := range revisions {
+		if r.SubtaskID == subtaskID && r.Revision <= revision {
+			statement = r.Statement
+		}
+	}
+	return statement
+}
+
+// 行単位の diff を LCS で求める
+func difflines(before string, after string) []DiffLine {
+	a := strings.Split(before, "\n")
+	b := strings.Split(after, "\n")
+
+	// lcs[i][j] は a[i:] と b[j:] の LCS の長さ
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	lines := []DiffLine{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			lines = append(lines, DiffLine{Op: "equal", Text: a[i]})
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			lines = append(lines, DiffLine{Op: "delete", Text: a[i]})
+			i++
+		} else {
+			lines = append(lines, DiffLine{Op: "insert", Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, DiffLine{Op: "delete", Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, DiffLine{Op: "insert", Text: b[j]})
+	}
+	return lines
+}
+
+// GET /api/tasks/:taskname/diff
+func getStatementDiffHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	task, revisions, subtasks_map, err := getrevisions(ctx, c.Param("taskname"))
+	if err == sql.ErrNoRows {
+		return echo.NewHTTPError(http.StatusNotFound, "task not found")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get revisions: "+err.Error())
+	}
+
+	current := 0
+	for _, revision := range revisions {
+		current = max(current, revision.Revision)
+	}
+
+	// to を省略すると最新の改訂、from を省略すると to の 1 つ前の改訂と比較する
+	to := current
+	if c.QueryParam("to") != "" {
+		to, err = strconv.Atoi(c.QueryParam("to"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "failed to parse to: "+err.Error())
+		}
+	}
+	from := max(to-1, 1)
+	if c.QueryParam("from") != "" {
+		from, err = strconv.Atoi(c.QueryParam("from"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "failed to parse from: "+err.Error())
+		}
+	}
+	if from < 1 || to < 1 || from > current || to > current {
+		return echo.NewHTTPError(http.StatusBadRequest, "revision out of range")
+	}
+
+	res := StatementDiffResponse{
+		TaskName: task.Name,
+		From:     from,
+		To:       to,
+		Subtasks: []SubtaskDiff{},
+	}
+
+	before := statementat(revisions, nullsubtaskid, from)
+	after := statementat(revisions, nullsubtaskid, to)
+	if before != after {
+		res.Statement = difflines(before, after)
+	}
+
+	// 小課題ごとに 1 回だけ比較する
+	compared := map[int]bool{}
+	for _, revision := range revisions {
+		if revision.SubtaskID == nullsubtaskid || compared[revision.SubtaskID] {
+			continue
+		}
+		compared[revision.SubtaskID] = true
+		before := statementat(revisions, revision.SubtaskID, from)
+		after := statementat(revisions, revision.SubtaskID, to)
+		if before == after {
+			continue
+		}
+		subtask := subtasks_map[revision.SubtaskID]
+		res.Subtasks = append(res.Subtasks, SubtaskDiff{
+			Name:        subtask.Name,
+			DisplayName: subtask.DisplayName,
+			Lines:       difflines(before, after),
+		})
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+type RevisionNotice struct {
+	TaskName        string `json:"task_name"`
+	TaskDisplayName string `json:"task_display_name"`
+	Revision        int    `json:"revision"`
+	RevisedAt       int64  `json:"revised_at"`
+}
+
+// GET /api/revisions
+func getRevisionNoticesHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// since (UNIX 時間) より後に行われた改訂のみを返す。問題の作成 (改訂 1) は含めない
+	since := int64(0)
+	if c.QueryParam("since") != "" {
+		var err error
+		since, err = strconv.ParseInt(c.QueryParam("since"), 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "failed to parse since: "+err.Error())
+		}
+	}
+
+	type Res struct {
+		TaskName        string    `db:"name"`
+		TaskDisplayName string    `db:"display_name"`
+		Revision        int       `db:"revision"`
+		CreatedAt       time.Time `db:"created_at"`
+	}
+	var notices []Res
+	if err := dbConn.SelectContext(ctx, &notices, "SELECT tasks.name, tasks.display_name, statement_revisions.revision, MAX(statement_revisions.created_at) AS created_at FROM statement_revisions JOIN tasks ON statement_revisions.task_id = tasks.id WHERE statement_revisions.revision > 1 AND statement_revisions.created_at > ? GROUP BY tasks.id, statement_revisions.revision ORDER BY created_at, tasks.name", time.Unix(since, 0)); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get revisions: "+err.Error())
+	}
+
+	res := []RevisionNotice{}
+	for _, notice := range notices {
+		res = append(res, RevisionNotice{
+			TaskName:        notice.TaskName,
+			TaskDisplayName: notice.TaskDisplayName,
+			Revision:        notice.Revision,
+			RevisedAt:       notice.CreatedAt.Unix(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
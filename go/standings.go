@@ -0,0 +1,971 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// 順位表を計算するのに必要な状態
+// DB から一度だけ読み込み、その後は提出やヒントの解放のたびに差分を反映する
+type standingsstate struct {
+	tasks           []Task // 名前順
+	subtaskspertask map[int]([]Subtask)
+	taskofsubtask   map[int]Task
+	basemaxscores   map[int]int // 小課題の満点 (動的配点に換算する前)
+
+	teams      []Team // 名前順
+	teamofuser map[int]int
+	users      map[int]User
+
+	userscores  map[int](map[int]userscore) // ユーザー → 小課題 → 最高得点
+	submitted   map[int](map[int]bool)      // チーム → 問題 → 提出したか
+	hints       map[int]Hint
+	unlocks     map[int](map[int]bool)                // チーム → 解放したヒント
+	solves      map[int](map[int]bool)                // 小課題 → 満点で解いたチーム
+	wrongs      map[int](map[int](map[int]time.Time)) // ユーザー → 問題 → 不正解の提出 → 提出時刻
+	firstsolves map[int]firstsolve                    // 小課題 → 最初に解いたチーム
+	attempts    map[int](map[int](map[int]bool))      // ユーザー → 小課題 → 無効にしていない提出
+
+	// 計算済みの各チームの行。差分の反映では影響のあるチームの行だけ作り直す
+	rows map[int]TeamsStandings
+}
+
+// 問題やチームなど、提出やヒントの解放以外の状態を読み込む
+func loadstandingsbase(ctx context.Context, q sqlx.QueryerContext) (*standingsstate, error) {
+	tasks := []Task{}
+	if err := sqlx.SelectContext(ctx, q, &tasks, "SELECT * FROM tasks ORDER BY name"); err != nil {
+		return nil, err
+	}
+	subtasks := []Subtask{}
+	if err := sqlx.SelectContext(ctx, q, &subtasks, "SELECT * FROM subtasks ORDER BY id"); err != nil {
+		return nil, err
+	}
+	answers := []Answer{}
+	if err := sqlx.SelectContext(ctx, q, &answers, "SELECT * FROM answers"); err != nil {
+		return nil, err
+	}
+	users := []User{}
+	if err := sqlx.SelectContext(ctx, q, &users, "SELECT * FROM users"); err != nil {
+		return nil, err
+	}
+	teams := []Team{}
+	if err := sqlx.SelectContext(ctx, q, &teams, "SELECT * FROM teams ORDER BY name"); err != nil {
+		return nil, err
+	}
+	hints := []Hint{}
+	if err := sqlx.SelectContext(ctx, q, &hints, "SELECT * FROM hints"); err != nil {
+		return nil, err
+	}
+	return newstandingsstate(tasks, subtasks, answers, users, teams, hints), nil
+}
+
+// 提出やヒントの解放がない状態を作る。tasks と teams は名前順、subtasks は ID 順で渡す
+func newstandingsstate(tasks []Task, subtasks []Subtask, answers []Answer, users []User, teams []Team, hints []Hint) *standingsstate {
+	s := &standingsstate{
+		tasks:           tasks,
+		subtaskspertask: map[int]([]Subtask){},
+		taskofsubtask:   map[int]Task{},
+		basemaxscores:   map[int]int{},
+		teams:           teams,
+		teamofuser:      map[int]int{},
+		users:           map[int]User{},
+		userscores:      map[int](map[int]userscore){},
+		submitted:       map[int](map[int]bool){},
+		hints:           map[int]Hint{},
+		unlocks:         map[int](map[int]bool){},
+		solves:          map[int](map[int]bool){},
+		wrongs:          map[int](map[int](map[int]time.Time)){},
+		firstsolves:     map[int]firstsolve{},
+		attempts:        map[int](map[int](map[int]bool)){},
+		rows:            map[int]TeamsStandings{},
+	}
+
+	taskbyid := map[int]Task{}
+	for _, task := range s.tasks {
+		taskbyid[task.ID] = task
+	}
+	for _, subtask := range subtasks {
+		s.subtaskspertask[subtask.TaskID] = append(s.subtaskspertask[subtask.TaskID], subtask)
+		s.taskofsubtask[subtask.ID] = taskbyid[subtask.TaskID]
+		s.basemaxscores[subtask.ID] = subtask.MaxScore
+	}
+	for _, answer := range answers {
+		s.basemaxscores[answer.SubtaskID] = max(s.basemaxscores[answer.SubtaskID], answer.Score)
+	}
+
+	for _, user := range users {
+		s.users[user.ID] = user
+	}
+	for _, team := range s.teams {
+		for _, userID := range teammemberids(team) {
+			s.teamofuser[userID] = team.ID
+		}
+	}
+
+	for _, hint := range hints {
+		s.hints[hint.ID] = hint
+	}
+
+	s.rebuildall()
+	return s
+}
+
+// at がゼロ値でなければ、at より前の提出とヒントの解放だけを数える
+func loadstandingsstate(ctx context.Context, q sqlx.QueryerContext, at time.Time) (*standingsstate, error) {
+	s, err := loadstandingsbase(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	// 提出は多いので、1 件ずつ読みながら差分の反映と同じ addsubmission で集計する
+	// 同点の順位付けに得点した時刻が要るので、subtask_scores_of_user や subtask_solves ではなく提出から集計する
+	rows, err := q.QueryxContext(ctx, "SELECT id, task_id, user_id, target_subtask_id, subtask_id, score, verdict, submitted_at FROM submissions"+beforecondition("submitted_at", at), beforeparams(at)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		submission := Submission{}
+		if err := rows.StructScan(&submission); err != nil {
+			return nil, err
+		}
+		s.addsubmission(submission)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	type Unlock struct {
+		TeamID int `db:"team_id"`
+		HintID int `db:"hint_id"`
+	}
+	var unlocks []Unlock
+	if err := sqlx.SelectContext(ctx, q, &unlocks, "SELECT team_id, hint_id FROM hint_unlocks"+beforecondition("unlocked_at", at), beforeparams(at)...); err != nil {
+		return nil, err
+	}
+	for _, unlock := range unlocks {
+		s.setunlock(unlock.TeamID, unlock.HintID)
+	}
+
+	s.rebuildall()
+	return s, nil
+}
+
+// 小課題を最初に解いたチームと、その提出
+type firstsolve struct {
+	teamID       int
+	submissionID int
+}
+
+// 時刻で絞り込む WHERE 句。at がゼロ値なら絞り込まない
+func beforecondition(column string, at time.Time) string {
+	if at.IsZero() {
+		return ""
+	}
+	return " WHERE " + column + " < ?"
+}
+
+func beforeparams(at time.Time) []interface{} {
+	if at.IsZero() {
+		return nil
+	}
+	return []interface{}{at}
+}
+
+// 以下の set* は何度呼んでも結果が変わらない。変化があった場合に true を返す
+
+// ユーザーの小課題の最高得点と、それを初めて取った時刻
+type userscore struct {
+	score int
+	at    time.Time
+}
+
+func (s *standingsstate) setuserscore(userID int, subtaskID int, score int, at time.Time) bool {
+	if _, ok := s.userscores[userID]; !ok {
+		s.userscores[userID] = map[int]userscore{}
+	}
+	// 非同期に判定する場合、提出の順に反映されるとは限らない
+	if prev, ok := s.userscores[userID][subtaskID]; ok && (prev.score > score || (prev.score == score && !at.Before(prev.at))) {
+		return false
+	}
+	s.userscores[userID][subtaskID] = userscore{score: score, at: at}
+	return true
+}
+
+// 不正解の提出や提出回数は提出の ID で区別するので、読み込みと同じ提出を反映しても二重に数えない
+func (s *standingsstate) addwrong(userID int, taskID int, submissionID int, at time.Time) bool {
+	if _, ok := s.wrongs[userID]; !ok {
+		s.wrongs[userID] = map[int](map[int]time.Time){}
+	}
+	if _, ok := s.wrongs[userID][taskID]; !ok {
+		s.wrongs[userID][taskID] = map[int]time.Time{}
+	}
+	if _, ok := s.wrongs[userID][taskID][submissionID]; ok {
+		return false
+	}
+	s.wrongs[userID][taskID][submissionID] = at
+	return true
+}
+
+func (s *standingsstate) addattempt(userID int, subtaskID int, submissionID int) bool {
+	if _, ok := s.attempts[userID]; !ok {
+		s.attempts[userID] = map[int](map[int]bool){}
+	}
+	if _, ok := s.attempts[userID][subtaskID]; !ok {
+		s.attempts[userID][subtaskID] = map[int]bool{}
+	}
+	if s.attempts[userID][subtaskID][submissionID] {
+		return false
+	}
+	s.attempts[userID][subtaskID][submissionID] = true
+	return true
+}
+
+// ユーザーたちの、問題への不正解の提出の時刻
+func (s *standingsstate) wrongtimes(userIDs []int, taskID int) []time.Time {
+	wrongs := []time.Time{}
+	for _, userID := range userIDs {
+		for _, at := range s.wrongs[userID][taskID] {
+			wrongs = append(wrongs, at)
+		}
+	}
+	return wrongs
+}
+
+func (s *standingsstate) setsubmitted(userID int, taskID int) bool {
+	teamID, ok := s.teamofuser[userID]
+	if !ok {
+		return false
+	}
+	if _, ok := s.submitted[teamID]; !ok {
+		s.submitted[teamID] = map[int]bool{}
+	}
+	if s.submitted[teamID][taskID] {
+		return false
+	}
+	s.submitted[teamID][taskID] = true
+	return true
+}
+
+func (s *standingsstate) setunlock(teamID int, hintID int) bool {
+	if _, ok := s.unlocks[teamID]; !ok {
+		s.unlocks[teamID] = map[int]bool{}
+	}
+	if s.unlocks[teamID][hintID] {
+		return false
+	}
+	s.unlocks[teamID][hintID] = true
+	return true
+}
+
+func (s *standingsstate) setsolve(subtaskID int, teamID int) bool {
+	if _, ok := s.solves[subtaskID]; !ok {
+		s.solves[subtaskID] = map[int]bool{}
+	}
+	if s.solves[subtaskID][teamID] {
+		return false
+	}
+	s.solves[subtaskID][teamID] = true
+	return true
+}
+
+// 小課題の現在の満点。動的配点の場合は解いたチーム数で決まる
+func (s *standingsstate) subtaskmaxscore(subtask Subtask) int {
+	task := s.taskofsubtask[subtask.ID]
+	if task.isdynamic() {
+		return task.dynamicvalue(len(s.solves[subtask.ID]))
+	}
+	return s.basemaxscores[subtask.ID]
+}
+
+func (s *standingsstate) buildrow(team Team) TeamsStandings {
+	row := TeamsStandings{
+		TeamName:        team.Name,
+		TeamDisplayName: team.DisplayName,
+		ScoringData:     []TeamsStandingsSub{},
+	}
+
+	leader := s.users[team.LeaderID]
+	row.LeaderName = leader.Name
+	row.LeaderDisplayName = leader.DisplayName
+	if team.Member1ID != nulluserid {
+		member1 := s.users[team.Member1ID]
+		row.Member1Name = member1.Name
+		row.Member1DisplayName = member1.DisplayName
+	}
+	if team.Member2ID != nulluserid {
+		member2 := s.users[team.Member2ID]
+		row.Member2Name = member2.Name
+		row.Member2DisplayName = member2.DisplayName
+	}
+
+	// ヒントのコストは問題ごとに差し引く。問題の得点は 0 未満にはしない
+	hintcosts := map[int]int{}
+	for hintID := range s.unlocks[team.ID] {
+		hint := s.hints[hintID]
+		hintcosts[hint.TaskID] += hint.Cost
+		row.HintsUsed++
+	}
+
+	// 最後に得点が上がった時刻。小課題ごとに、チームの最高得点を初めて取った時刻の最大値
+	lastscoreat := time.Time{}
+	// 得点した問題への不正解の提出の時刻
+	wrongs := []time.Time{}
+	members := teammemberids(team)
+	for _, task := range s.tasks {
+		cell := TeamsStandingsSub{
+			TaskName:     task.Name,
+			HasSubmitted: s.submitted[team.ID][task.ID],
+			Subtasks:     []SubtaskStandings{},
+		}
+		for _, subtask := range s.subtaskspertask[task.ID] {
+			score, scoreat := s.teamsubtaskscore(members, task, subtask)
+			if score > 0 && scoreat.After(lastscoreat) {
+				lastscoreat = scoreat
+			}
+			cell.Score += score
+
+			subtaskcell := SubtaskStandings{SubtaskName: subtask.Name, Score: score}
+			for _, userID := range members {
+				subtaskcell.AttemptCount += len(s.attempts[userID][subtask.ID])
+			}
+			cell.Subtasks = append(cell.Subtasks, subtaskcell)
+			if firstsolve, ok := s.firstsolves[subtask.ID]; ok && firstsolve.teamID == team.ID {
+				cell.FirstSolve = true
+			}
+		}
+		cell.Score = max(cell.Score-hintcosts[task.ID], 0)
+		if cell.Score > 0 {
+			wrongs = append(wrongs, s.wrongtimes(members, task.ID)...)
+		}
+		row.ScoringData = append(row.ScoringData, cell)
+		row.TotalScore += cell.Score
+	}
+
+	if tiebreak != tiebreaknone {
+		row.Tiebreak = newtiebreak(lastscoreat, wrongs)
+	}
+	return row
+}
+
+// 小課題のチームの得点と、それを初めて取った時刻。メンバーの最高得点を取り、動的配点なら換算する
+func (s *standingsstate) teamsubtaskscore(members []int, task Task, subtask Subtask) (int, time.Time) {
+	score := 0
+	scoreat := time.Time{}
+	for _, userID := range members {
+		userscore := s.userscores[userID][subtask.ID]
+		if userscore.score > score || (userscore.score == score && userscore.at.Before(scoreat)) {
+			score, scoreat = userscore.score, userscore.at
+		}
+	}
+	if task.isdynamic() {
+		score = scaledynamicscore(score, s.basemaxscores[subtask.ID], s.subtaskmaxscore(subtask))
+	}
+	return score, scoreat
+}
+
+// 問題ごとの現在の満点
+func (s *standingsstate) tasksdata() []TaskAbstract {
+	tasksdata := []TaskAbstract{}
+	for _, task := range s.tasks {
+		taskdata := TaskAbstract{
+			Name:        task.Name,
+			DisplayName: task.DisplayName,
+			Subtasks:    []SubtaskAbstract{},
+		}
+		for _, subtask := range s.subtaskspertask[task.ID] {
+			maxscore := s.subtaskmaxscore(subtask)
+			taskdata.Subtasks = append(taskdata.Subtasks, SubtaskAbstract{
+				Name:        subtask.Name,
+				DisplayName: subtask.DisplayName,
+				MaxScore:    maxscore,
+			})
+			taskdata.MaxScore += maxscore
+		}
+		tasksdata = append(tasksdata, taskdata)
+	}
+	return tasksdata
+}
+
+// 計算済みの行から順位表を作る。返り値は以後変更しない
+func (s *standingsstate) build() *Standings {
+	rows := []TeamsStandings{}
+	for _, team := range s.teams {
+		rows = append(rows, s.rows[team.ID])
+	}
+	return &Standings{
+		TasksData:     s.tasksdata(),
+		StandingsData: rankstandings(rows),
+	}
+}
+
+// 同点のチームの順位付けに使う値
+type Tiebreak struct {
+	LastScoreAt      int64 `json:"last_score_at"` // 最後に得点が上がった時刻。得点していなければ 0
+	WrongSubmissions int   `json:"wrong_submissions"`
+	PenaltyMinutes   int   `json:"penalty_minutes"`
+	Value            int64 `json:"value"` // last_score_at にペナルティを加えた値。小さいほど上位
+}
+
+// ICPC と同様に、最後に得点が上がった時刻までの不正解だけをペナルティに数える
+// wrongs には得点した問題への不正解だけを渡す
+func newtiebreak(lastscoreat time.Time, wrongs []time.Time) *Tiebreak {
+	t := &Tiebreak{}
+	if lastscoreat.IsZero() {
+		return t
+	}
+	t.LastScoreAt = lastscoreat.Unix()
+	for _, wrong := range wrongs {
+		if !wrong.After(lastscoreat) {
+			t.WrongSubmissions++
+		}
+	}
+	t.PenaltyMinutes = t.WrongSubmissions * tiebreakpenaltyminutes
+	t.Value = t.LastScoreAt + int64(t.PenaltyMinutes)*60
+	return t
+}
+
+// 同点の順位付けをしない場合は nil で、すべてのチームが同じ値になる
+func (t *Tiebreak) value() int64 {
+	if t == nil {
+		return 0
+	}
+	return t.Value
+}
+
+// 得点の高い順、同点なら同点の順位付けの値の小さい順、チーム名順に並べ、順位を付ける
+func rankstandings(rows []TeamsStandings) []TeamsStandings {
+	sort.Slice(rows, func(i, j int) bool {
+		return rankless(rows[i].TotalScore, rows[i].Tiebreak, rows[i].TeamName, rows[j].TotalScore, rows[j].Tiebreak, rows[j].TeamName)
+	})
+	// 順位は自分より上位のチームの数 + 1。得点も同点の順位付けの値も同じなら同じ順位
+	for i := range rows {
+		if i > 0 && rankequal(rows[i].TotalScore, rows[i].Tiebreak, rows[i-1].TotalScore, rows[i-1].Tiebreak) {
+			rows[i].Rank = rows[i-1].Rank
+		} else {
+			rows[i].Rank = i + 1
+		}
+	}
+	return rows
+}
+
+// チームとユーザーの順位表で共通の並べ方
+func rankless(ascore int, atiebreak *Tiebreak, aname string, bscore int, btiebreak *Tiebreak, bname string) bool {
+	if ascore != bscore {
+		return ascore > bscore
+	}
+	if a, b := atiebreak.value(), btiebreak.value(); a != b {
+		return a < b
+	}
+	return aname < bname
+}
+
+func rankequal(ascore int, atiebreak *Tiebreak, bscore int, btiebreak *Tiebreak) bool {
+	return ascore == bscore && atiebreak.value() == btiebreak.value()
+}
+
+// チームの行を作り直す
+func (s *standingsstate) rebuildteam(teamID int) {
+	for _, team := range s.teams {
+		if team.ID == teamID {
+			s.rows[team.ID] = s.buildrow(team)
+			return
+		}
+	}
+}
+
+func (s *standingsstate) rebuildall() {
+	for _, team := range s.teams {
+		s.rows[team.ID] = s.buildrow(team)
+	}
+}
+
+// 提出 1 件を状態に加える。行は作り直さず、作り直すチームを返す
+// 読み込みと差分の反映で同じ集計になるよう、どちらもこれを使う。同じ提出を何度加えても結果は変わらない
+func (s *standingsstate) addsubmission(submission Submission) (changed bool, rebuildteamIDs []int, rebuildall bool) {
+	// チームに入っていないユーザーも、ユーザーの順位表のために得点は記録する
+	teamID, hasteam := s.teamofuser[submission.UserID]
+
+	if s.setsubmitted(submission.UserID, submission.TaskID) {
+		changed = true
+	}
+	if submission.Verdict == verdictwrong && s.addwrong(submission.UserID, submission.TaskID, submission.ID, submission.SubmittedAt) {
+		changed = true
+	}
+	// 小課題を指定しない提出は、得点した小課題への提出として数える
+	attemptsubtaskID := submission.TargetSubtaskID
+	if attemptsubtaskID == nullsubtaskid {
+		attemptsubtaskID = submission.SubtaskID
+	}
+	if attemptsubtaskID != nullsubtaskid && submission.Verdict != verdictinvalid && s.addattempt(submission.UserID, attemptsubtaskID, submission.ID) {
+		changed = true
+	}
+	if submission.SubtaskID != nullsubtaskid && submission.Verdict != verdictinvalid && submission.Verdict != verdictpending {
+		if s.setuserscore(submission.UserID, submission.SubtaskID, submission.Score, submission.SubmittedAt) {
+			changed = true
+		}
+		if hasteam && submission.Verdict == verdictaccepted {
+			if s.setsolve(submission.SubtaskID, teamID) {
+				changed = true
+				// 動的配点では他のチームの得点も変わる
+				rebuildall = s.taskofsubtask[submission.SubtaskID].isdynamic()
+			}
+			// insertfirstsolve と同じく、ID が最小の提出を最初に解いたものとする
+			if prev, ok := s.firstsolves[submission.SubtaskID]; !ok || submission.ID < prev.submissionID {
+				s.firstsolves[submission.SubtaskID] = firstsolve{teamID: teamID, submissionID: submission.ID}
+				changed = true
+				if ok && prev.teamID != teamID {
+					rebuildteamIDs = append(rebuildteamIDs, prev.teamID)
+				}
+			}
+		}
+	}
+	if changed && hasteam {
+		rebuildteamIDs = append(rebuildteamIDs, teamID)
+	}
+	return changed, rebuildteamIDs, rebuildall
+}
+
+// 判定の終わった提出を反映する。順位表が変わった場合に true を返す
+// 同じ提出を何度反映しても結果は変わらない
+func (s *standingsstate) applysubmission(submission Submission) bool {
+	changed, rebuildteamIDs, rebuildall := s.addsubmission(submission)
+	if rebuildall {
+		s.rebuildall()
+		return changed
+	}
+	for _, teamID := range rebuildteamIDs {
+		s.rebuildteam(teamID)
+	}
+	return changed
+}
+
+func (s *standingsstate) applyhintunlock(teamID int, hint Hint) bool {
+	s.hints[hint.ID] = hint
+	if !s.setunlock(teamID, hint.ID) {
+		return false
+	}
+	s.rebuildteam(teamID)
+	return true
+}
+
+// 順位表を保持し、読み出しには不変のスナップショットを返す
+type standingsengineT struct {
+	mu sync.Mutex // 状態の更新を直列化する
+
+	live *standingsstate
+	// 凍結時刻の時点の状態。凍結の設定がない場合は nil
+	frozen *standingsstate
+	// 凍結を解除したチーム
+	revealed map[int]bool
+
+	// 小課題ごとの内訳を含むものと、含まないもの (compact) を持つ
+	livesnapshot   atomic.Pointer[Standings]
+	livecompact    atomic.Pointer[Standings]
+	publicsnapshot atomic.Pointer[Standings] // 凍結中に参加者に見せる順位表
+	publiccompact  atomic.Pointer[Standings]
+
+	// ユーザーの順位表は読まれたときに作り、状態が変わったら捨てる
+	liveusers   []UserStandings
+	frozenusers []UserStandings
+}
+
+var standingsengine = &standingsengineT{}
+
+var errStandingsReloading = errors.New("standings are being reloaded")
+
+// DB から状態を読み込み直す。状態の更新はロックの中で行うので、読み込み中の差分の反映は読み込み後に行われる
+// 反映を待っている提出がすでに読み込んだものに含まれていても、提出の ID で区別するので二重には数えない
+func (e *standingsengineT) reload(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.load(ctx); err != nil {
+		// 次の読み出しで読み込み直す
+		e.clear()
+		return err
+	}
+	e.publish()
+	return nil
+}
+
+func (e *standingsengineT) load(ctx context.Context) error {
+	// 複数のテーブルを読むので、同じ時点のスナップショットから読む
+	tx, err := dbConn.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	live, err := loadstandingsstate(ctx, tx, time.Time{})
+	if err != nil {
+		return err
+	}
+	var frozen *standingsstate
+	revealed := map[int]bool{}
+	if freezeat := scoreboardfreezeat(); !freezeat.IsZero() {
+		frozen, err = loadstandingsstate(ctx, tx, freezeat)
+		if err != nil {
+			return err
+		}
+		revealed, err = getrevealedteams(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+	e.live, e.frozen, e.revealed = live, frozen, revealed
+	return nil
+}
+
+func (e *standingsengineT) clear() {
+	replaycache.clear()
+	e.live, e.frozen, e.revealed = nil, nil, nil
+	e.liveusers, e.frozenusers = nil, nil
+	e.livesnapshot.Store(nil)
+	e.livecompact.Store(nil)
+	e.publicsnapshot.Store(nil)
+	e.publiccompact.Store(nil)
+}
+
+// スナップショットを作り直す
+func (e *standingsengineT) publish() {
+	replaycache.clear()
+	e.liveusers, e.frozenusers = nil, nil
+	live := e.live.build()
+	e.livesnapshot.Store(live)
+	e.livecompact.Store(compactstandings(live))
+	if e.frozen == nil {
+		e.publicsnapshot.Store(nil)
+		e.publiccompact.Store(nil)
+		return
+	}
+
+	// 凍結を解除したチームは現在の行を、それ以外は凍結時刻の行を見せる
+	rows := []TeamsStandings{}
+	allrevealed := true
+	for _, team := range e.live.teams {
+		if e.revealed[team.ID] {
+			rows = append(rows, e.live.rows[team.ID])
+			continue
+		}
+		allrevealed = false
+		if row, ok := e.frozen.rows[team.ID]; ok {
+			rows = append(rows, row)
+		}
+	}
+	tasksdata := e.frozen.tasksdata()
+	if allrevealed {
+		tasksdata = e.live.tasksdata()
+	}
+	public := &Standings{
+		TasksData:     tasksdata,
+		StandingsData: rankstandings(rows),
+	}
+	e.publicsnapshot.Store(public)
+	e.publiccompact.Store(compactstandings(public))
+}
+
+// 小課題ごとの内訳を除いた順位表を返す
+func compactstandings(standings *Standings) *Standings {
+	compact := &Standings{
+		TasksData:     make([]TaskAbstract, len(standings.TasksData)),
+		StandingsData: make([]TeamsStandings, len(standings.StandingsData)),
+	}
+	for i, task := range standings.TasksData {
+		task.Subtasks = nil
+		compact.TasksData[i] = task
+	}
+	for i, row := range standings.StandingsData {
+		scoringdata := make([]TeamsStandingsSub, len(row.ScoringData))
+		for j, cell := range row.ScoringData {
+			cell.Subtasks = nil
+			scoringdata[j] = cell
+		}
+		row.ScoringData = scoringdata
+		compact.StandingsData[i] = row
+	}
+	return compact
+}
+
+// スナップショットのうち、detail なら内訳を含むものを返す
+func loadsnapshot(detail bool, snapshot *atomic.Pointer[Standings], compact *atomic.Pointer[Standings]) *Standings {
+	if detail {
+		return snapshot.Load()
+	}
+	return compact.Load()
+}
+
+// 状態を捨て、次の読み出しで DB から読み込み直させる
+// 差分では反映しにくい変更 (問題やチームの追加、再判定など) の後に呼ぶ
+func (e *standingsengineT) invalidate() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.clear()
+}
+
+// 現在の順位表を返す。まだ読み込んでいない場合は読み込む
+// detail なら小課題ごとの内訳を含める
+func (e *standingsengineT) get(ctx context.Context, detail bool) (*Standings, error) {
+	if standings := loadsnapshot(detail, &e.livesnapshot, &e.livecompact); standings != nil {
+		return standings, nil
+	}
+	if err := e.reload(ctx); err != nil {
+		return nil, err
+	}
+	if standings := loadsnapshot(detail, &e.livesnapshot, &e.livecompact); standings != nil {
+		return standings, nil
+	}
+	return nil, errStandingsReloading
+}
+
+// 参加者に見せる順位表を返す。凍結中は凍結時刻の時点のものになる
+func (e *standingsengineT) getpublic(ctx context.Context, now time.Time, detail bool) (*Standings, error) {
+	if !isscoreboardfrozen(now) {
+		return e.get(ctx, detail)
+	}
+	if standings := loadsnapshot(detail, &e.publicsnapshot, &e.publiccompact); standings != nil {
+		return standings, nil
+	}
+	if err := e.reload(ctx); err != nil {
+		return nil, err
+	}
+	if standings := loadsnapshot(detail, &e.publicsnapshot, &e.publiccompact); standings != nil {
+		return standings, nil
+	}
+	// 読み込んだ直後に捨てられた場合。凍結中の順位表の代わりに現在のものを見せることはしない
+	return nil, errStandingsReloading
+}
+
+// 判定の終わった提出を反映する。得点しなかった提出は SubtaskID を nullsubtaskid にする
+func (e *standingsengineT) applysubmission(submission Submission) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// 順位表を読み込んでいなくても、得点の推移や過去の順位表は変わるので捨てる
+	replaycache.clear()
+	if e.live == nil {
+		return
+	}
+	changed := e.live.applysubmission(submission)
+	if e.frozen != nil && submission.SubmittedAt.Before(scoreboardfreezeat()) {
+		// 凍結時刻より前の提出が後から判定された場合
+		if e.frozen.applysubmission(submission) {
+			changed = true
+		}
+	}
+	if changed {
+		e.publish()
+	}
+}
+
+// ヒントの解放を反映する
+func (e *standingsengineT) applyhintunlock(teamID int, hint Hint, at time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	replaycache.clear()
+	if e.live == nil {
+		return
+	}
+	changed := e.live.applyhintunlock(teamID, hint)
+	if e.frozen != nil && at.Before(scoreboardfreezeat()) {
+		if e.frozen.applyhintunlock(teamID, hint) {
+			changed = true
+		}
+	}
+	if changed {
+		e.publish()
+	}
+}
+
+// チームの凍結を解除する
+func (e *standingsengineT) reveal(teamIDs []int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.live == nil || e.frozen == nil {
+		return
+	}
+	for _, teamID := range teamIDs {
+		e.revealed[teamID] = true
+	}
+	e.publish()
+}
+
+// 参加者に見せてよい時刻の上限 (この時刻を含まない)。上限がなければゼロ値
+// 凍結中で、まだ凍結を解除していないチームがあれば凍結時刻になる
+func (e *standingsengineT) publicuntil(ctx context.Context, now time.Time) (time.Time, error) {
+	if !isscoreboardfrozen(now) {
+		return time.Time{}, nil
+	}
+	if _, err := e.get(ctx, false); err != nil {
+		return time.Time{}, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.live != nil {
+		allrevealed := true
+		for _, team := range e.live.teams {
+			if !e.revealed[team.ID] {
+				allrevealed = false
+			}
+		}
+		if allrevealed {
+			return time.Time{}, nil
+		}
+	}
+	return scoreboardfreezeat(), nil
+}
+
+// 参加者に見せる、動的配点の小課題の満点。凍結中は解いたチーム数が分からないよう、凍結時刻の時点の満点にする
+// 凍結中でなければ nil を返す
+func (e *standingsengineT) publicmaxscores(ctx context.Context, now time.Time) (map[int]int, error) {
+	until, err := e.publicuntil(ctx, now)
+	if err != nil || until.IsZero() {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// 読み込んだ直後に捨てられた場合。現在の満点を代わりに見せることはしない
+	if e.frozen == nil {
+		return nil, errStandingsReloading
+	}
+	maxscores := map[int]int{}
+	for _, task := range e.frozen.tasks {
+		if !task.isdynamic() {
+			continue
+		}
+		for _, subtask := range e.frozen.subtaskspertask[task.ID] {
+			maxscores[subtask.ID] = e.frozen.subtaskmaxscore(subtask)
+		}
+	}
+	return maxscores, nil
+}
+
+// 全体を計算し直した順位表を返す。差分の反映の確認と、過去の順位表に使う
+// at がゼロ値でなければ、at より前の提出とヒントの解放だけを数える
+func getstandings(ctx context.Context, at time.Time) (*Standings, error) {
+	tx, err := dbConn.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	state, err := loadstandingsstate(ctx, tx, at)
+	if err != nil {
+		return nil, err
+	}
+	return state.build(), nil
+}
+
+type StandingsVerifyResponse struct {
+	Consistent bool     `json:"consistent"`
+	TasksDiff  []string `json:"tasks_diff"` // 満点が食い違う問題
+	TeamsDiff  []string `json:"teams_diff"` // 行が食い違うチーム
+	// 凍結時刻の時点の順位表についての結果
+	Frozen *StandingsVerifyResponse `json:"frozen,omitempty"`
+}
+
+// 差分を反映してきた順位表と、DB から計算し直した順位表を比べる
+func verifystandings(ctx context.Context) (StandingsVerifyResponse, error) {
+	if _, err := standingsengine.get(ctx, false); err != nil {
+		return StandingsVerifyResponse{}, err
+	}
+
+	// 比べている間に状態が変わらないようにする
+	standingsengine.mu.Lock()
+	defer standingsengine.mu.Unlock()
+
+	if standingsengine.live == nil {
+		if err := standingsengine.load(ctx); err != nil {
+			return StandingsVerifyResponse{}, err
+		}
+		standingsengine.publish()
+	}
+
+	expected, err := getstandings(ctx, time.Time{})
+	if err != nil {
+		return StandingsVerifyResponse{}, err
+	}
+	res := comparestandings(*standingsengine.live.build(), *expected)
+
+	if standingsengine.frozen != nil {
+		expected, err := getstandings(ctx, scoreboardfreezeat())
+		if err != nil {
+			return StandingsVerifyResponse{}, err
+		}
+		frozen := comparestandings(*standingsengine.frozen.build(), *expected)
+		res.Frozen = &frozen
+		res.Consistent = res.Consistent && frozen.Consistent
+	}
+	return res, nil
+}
+
+func comparestandings(current Standings, expected Standings) StandingsVerifyResponse {
+	res := StandingsVerifyResponse{TasksDiff: []string{}, TeamsDiff: []string{}}
+
+	currenttasks := map[string]TaskAbstract{}
+	for _, task := range current.TasksData {
+		currenttasks[task.Name] = task
+	}
+	for _, task := range expected.TasksData {
+		if !reflect.DeepEqual(currenttasks[task.Name], task) {
+			res.TasksDiff = append(res.TasksDiff, task.Name)
+		}
+		delete(currenttasks, task.Name)
+	}
+	for name := range currenttasks {
+		res.TasksDiff = append(res.TasksDiff, name)
+	}
+
+	currentteams := map[string]TeamsStandings{}
+	for _, row := range current.StandingsData {
+		currentteams[row.TeamName] = row
+	}
+	for _, row := range expected.StandingsData {
+		if !reflect.DeepEqual(currentteams[row.TeamName], row) {
+			res.TeamsDiff = append(res.TeamsDiff, row.TeamName)
+		}
+		delete(currentteams, row.TeamName)
+	}
+	for name := range currentteams {
+		res.TeamsDiff = append(res.TeamsDiff, name)
+	}
+	sort.Strings(res.TasksDiff)
+	sort.Strings(res.TeamsDiff)
+
+	res.Consistent = len(res.TasksDiff) == 0 && len(res.TeamsDiff) == 0
+	return res
+}
+
+// GET /api/admin/standings/verify
+// 差分を反映してきた順位表が、全体を計算し直したものと一致するか確かめる
+func verifyStandingsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyAdminSession(c); err != nil {
+		return err
+	}
+
+	res, err := verifystandings(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to verify standings: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type SubtaskExport struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	MaxScore    int    `json:"max_score"`
+}
+type TaskExport struct {
+	Name        string          `json:"name"`
+	DisplayName string          `json:"display_name"`
+	MaxScore    int             `json:"max_score"`
+	Subtasks    []SubtaskExport `json:"subtasks"`
+}
+type SubtaskScoreExport struct {
+	SubtaskName string `json:"subtask_name"`
+	Score       int    `json:"score"`
+}
+type TaskScoreExport struct {
+	TaskName string               `json:"task_name"`
+	Score    int                  `json:"score"` // ヒントのコストを引いた後の得点 (0 未満にはしない)
+	HintCost int                  `json:"hint_cost"`
+	Subtasks []SubtaskScoreExport `json:"subtasks"`
+}
+type TeamExport struct {
+	Rank            int               `json:"rank"`
+	TeamName        string            `json:"team_name"`
+	TeamDisplayName string            `json:"team_display_name"`
+	Members         []string          `json:"members"`
+	TotalScore      int               `json:"total_score"`
+	HintsUsed       int               `json:"hints_used"`
+	Tiebreak        *Tiebreak         `json:"tiebreak,omitempty"`
+	Tasks           []TaskScoreExport `json:"tasks"`
+}
+type StandingsExport struct {
+	Tasks []TaskExport `json:"tasks"`
+	Teams []TeamExport `json:"teams"`
+}
+
+// 現在の順位表を、小課題ごとの得点まで含めて書き出せる形にする
+func (s *standingsstate) export() StandingsExport {
+	res := StandingsExport{Tasks: []TaskExport{}, Teams: []TeamExport{}}
+
+	for _, task := range s.tasks {
+		taskexport := TaskExport{Name: task.Name, DisplayName: task.DisplayName, Subtasks: []SubtaskExport{}}
+		for _, subtask := range s.subtaskspertask[task.ID] {
+			maxscore := s.subtaskmaxscore(subtask)
+			taskexport.Subtasks = append(taskexport.Subtasks, SubtaskExport{Name: subtask.Name, DisplayName: subtask.DisplayName, MaxScore: maxscore})
+			taskexport.MaxScore += maxscore
+		}
+		res.Tasks = append(res.Tasks, taskexport)
+	}
+
+	teams := map[string]Team{}
+	for _, team := range s.teams {
+		teams[team.Name] = team
+	}
+	for _, row := range s.build().StandingsData {
+		team := teams[row.TeamName]
+		members := teammemberids(team)
+		teamexport := TeamExport{
+			Rank:            row.Rank,
+			TeamName:        row.TeamName,
+			TeamDisplayName: row.TeamDisplayName,
+			Members:         []string{},
+			TotalScore:      row.TotalScore,
+			HintsUsed:       row.HintsUsed,
+			Tiebreak:        row.Tiebreak,
+			Tasks:           []TaskScoreExport{},
+		}
+		for _, userID := range members {
+			teamexport.Members = append(teamexport.Members, s.users[userID].Name)
+		}
+		hintcosts := map[int]int{}
+		for hintID := range s.unlocks[team.ID] {
+			hint := s.hints[hintID]
+			hintcosts[hint.TaskID] += hint.Cost
+		}
+		for i, task := range s.tasks {
+			taskscore := TaskScoreExport{TaskName: task.Name, Score: row.ScoringData[i].Score, HintCost: hintcosts[task.ID], Subtasks: []SubtaskScoreExport{}}
+			for _, subtask := range s.subtaskspertask[task.ID] {
+				score, _ := s.teamsubtaskscore(members, task, subtask)
+				taskscore.Subtasks = append(taskscore.Subtasks, SubtaskScoreExport{SubtaskName: subtask.Name, Score: score})
+			}
+			teamexport.Tasks = append(teamexport.Tasks, taskscore)
+		}
+		res.Teams = append(res.Teams, teamexport)
+	}
+	return res
+}
+
+// 現在の状態から書き出す。書き出している間に状態が変わらないようにする
+func (e *standingsengineT) export(ctx context.Context) (StandingsExport, error) {
+	if _, err := e.get(ctx, false); err != nil {
+		return StandingsExport{}, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.live == nil {
+		if err := e.load(ctx); err != nil {
+			e.clear()
+			return StandingsExport{}, err
+		}
+		e.publish()
+	}
+	return e.live.export(), nil
+}
+
+func (res StandingsExport) csvheader() []string {
+	header := []string{"rank", "team_name", "team_display_name", "members", "total_score", "hints_used", "tiebreak"}
+	for _, task := range res.Tasks {
+		header = append(header, task.Name)
+		for _, subtask := range task.Subtasks {
+			header = append(header, task.Name+"/"+subtask.Name)
+		}
+	}
+	return header
+}
+
+func (team TeamExport) csvrecord() []string {
+	record := []string{
+		strconv.Itoa(team.Rank),
+		team.TeamName,
+		team.TeamDisplayName,
+		strings.Join(team.Members, " "),
+		strconv.Itoa(team.TotalScore),
+		strconv.Itoa(team.HintsUsed),
+		strconv.FormatInt(team.Tiebreak.value(), 10),
+	}
+	for _, task := range team.Tasks {
+		record = append(record, strconv.Itoa(task.Score))
+		for _, subtask := range task.Subtasks {
+			record = append(record, strconv.Itoa(subtask.Score))
+		}
+	}
+	return record
+}
+
+// GET /api/admin/standings/export
+// 現在の順位表を CSV (format=csv) または JSON (format=json) で書き出す。凍結中でも凍結前の順位表にはしない
+func exportStandingsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyAdminSession(c); err != nil {
+		return err
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		return echo.NewHTTPError(http.StatusBadRequest, "format must be csv or json")
+	}
+
+	res, err := standingsengine.export(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get standings: "+err.Error())
+	}
+
+	if format == "json" {
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="standings.json"`)
+		return c.JSON(http.StatusOK, res)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="standings.csv"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	csvwriter := csv.NewWriter(c.Response())
+	if err := csvwriter.Write(res.csvheader()); err != nil {
+		return err
+	}
+	for _, team := range res.Teams {
+		if err := csvwriter.Write(team.csvrecord()); err != nil {
+			return err
+		}
+	}
+	csvwriter.Flush()
+	return csvwriter.Error()
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 提出の行。Answer は集計に使わないので省く
+func teststandingssubmission(id int, userID int, taskID int, targetSubtaskID int, subtaskID int, score int, verdict string, minute int) Submission {
+	return Submission{
+		ID:              id,
+		TaskID:          taskID,
+		UserID:          userID,
+		SubmittedAt:     teststandingsminute(minute),
+		TargetSubtaskID: targetSubtaskID,
+		SubtaskID:       subtaskID,
+		Score:           score,
+		Verdict:         verdict,
+	}
+}
+
+type teststandingsunlock struct {
+	teamID int
+	hintID int
+}
+
+var teststandingsbase = time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+func teststandingsminute(minute int) time.Time {
+	return teststandingsbase.Add(time.Duration(minute) * time.Minute)
+}
+
+// alpha は固定の配点、beta は動的配点の問題
+var teststandingstasks = []Task{
+	{ID: 1, Name: "alpha", DisplayName: "Alpha"},
+	{ID: 2, Name: "beta", DisplayName: "Beta", DynamicInitial: 500, DynamicMinimum: 100, DynamicDecay: 3},
+}
+var teststandingssubtasks = []Subtask{
+	{ID: 1, Name: "alpha-1", DisplayName: "Alpha 1", TaskID: 1},
+	{ID: 2, Name: "alpha-2", DisplayName: "Alpha 2", TaskID: 1},
+	{ID: 3, Name: "beta-1", DisplayName: "Beta 1", TaskID: 2},
+}
+var teststandingsanswers = []Answer{
+	{ID: 1, TaskID: 1, SubtaskID: 1, Answer: "a1", Score: 100},
+	{ID: 2, TaskID: 1, SubtaskID: 2, Answer: "a2", Score: 50},
+	{ID: 3, TaskID: 2, SubtaskID: 3, Answer: "b1", Score: 100},
+	{ID: 4, TaskID: 2, SubtaskID: 3, Answer: "b1-partial", Score: 50},
+}
+var teststandingsusers = []User{
+	{ID: 1, Name: "user1", DisplayName: "User 1"},
+	{ID: 2, Name: "user2", DisplayName: "User 2"},
+	{ID: 3, Name: "user3", DisplayName: "User 3"},
+	{ID: 4, Name: "user4", DisplayName: "User 4"},
+	{ID: 5, Name: "user5", DisplayName: "User 5"},
+}
+var teststandingsteams = []Team{
+	{ID: 1, Name: "apple", DisplayName: "Apple", LeaderID: 1, Member1ID: 2, Member2ID: nulluserid},
+	{ID: 2, Name: "banana", DisplayName: "Banana", LeaderID: 3, Member1ID: nulluserid, Member2ID: nulluserid},
+	{ID: 3, Name: "cherry", DisplayName: "Cherry", LeaderID: 4, Member1ID: 5, Member2ID: nulluserid},
+}
+var teststandingshints = []Hint{
+	{ID: 1, TaskID: 1, SubtaskID: 1, Position: 1, Content: "hint 1", Cost: 30},
+	{ID: 2, TaskID: 2, SubtaskID: 3, Position: 1, Content: "hint 2", Cost: 1000}, // 問題の得点より大きい
+}
+
+// 提出の ID 順
+var teststandingssubmissions = []Submission{
+	teststandingssubmission(1, 1, 1, nullsubtaskid, 1, 100, verdictaccepted, 5),
+	teststandingssubmission(2, 3, 1, nullsubtaskid, nullsubtaskid, 0, verdictwrong, 6),
+	teststandingssubmission(3, 3, 1, nullsubtaskid, 1, 100, verdictaccepted, 10),
+	teststandingssubmission(4, 4, 2, nullsubtaskid, nullsubtaskid, 0, verdictwrong, 12),
+	teststandingssubmission(5, 4, 2, nullsubtaskid, 3, 100, verdictaccepted, 15),
+	teststandingssubmission(6, 2, 2, nullsubtaskid, 3, 100, verdictaccepted, 20),
+	teststandingssubmission(7, 5, 1, nullsubtaskid, 2, 50, verdictaccepted, 25),
+	teststandingssubmission(8, 3, 2, nullsubtaskid, 3, 50, verdictpartial, 30),
+	teststandingssubmission(9, 1, 1, nullsubtaskid, 2, 50, verdictaccepted, 35),
+	teststandingssubmission(10, 5, 1, nullsubtaskid, nullsubtaskid, 0, verdictinvalid, 40),
+	teststandingssubmission(11, 2, 1, 1, nullsubtaskid, 0, verdictattemptlimit, 45),
+	teststandingssubmission(12, 3, 1, 2, nullsubtaskid, 0, verdictwrong, 50),
+}
+var teststandingsunlocks = []teststandingsunlock{
+	{teamID: 1, hintID: 1},
+	{teamID: 3, hintID: 2},
+}
+
+func setteststandingstiebreak(t *testing.T) {
+	prevtiebreak, prevpenalty := tiebreak, tiebreakpenaltyminutes
+	tiebreak, tiebreakpenaltyminutes = tiebreaklastscore, 20
+	t.Cleanup(func() {
+		tiebreak, tiebreakpenaltyminutes = prevtiebreak, prevpenalty
+	})
+}
+
+func newteststandingsstate() *standingsstate {
+	return newstandingsstate(teststandingstasks, teststandingssubtasks, teststandingsanswers, teststandingsusers, teststandingsteams, teststandingshints)
+}
+
+func teststandingshint(hintID int) Hint {
+	for _, hint := range teststandingshints {
+		if hint.ID == hintID {
+			return hint
+		}
+	}
+	panic("unknown hint")
+}
+
+// loadstandingsstate と同じく、提出の行を addsubmission で集計してから行を作る
+func loadteststandingsstate() *standingsstate {
+	s := newteststandingsstate()
+	for _, submission := range teststandingssubmissions {
+		s.addsubmission(submission)
+	}
+	for _, unlock := range teststandingsunlocks {
+		s.setunlock(unlock.teamID, unlock.hintID)
+	}
+	s.rebuildall()
+	return s
+}
+
+func TestStandingsIncrementalMatchesLoad(t *testing.T) {
+	setteststandingstiebreak(t)
+
+	s := newteststandingsstate()
+	for _, submission := range teststandingssubmissions {
+		s.applysubmission(submission)
+	}
+	for _, unlock := range teststandingsunlocks {
+		s.applyhintunlock(unlock.teamID, teststandingshint(unlock.hintID))
+	}
+
+	want := loadteststandingsstate().build()
+	if got := s.build(); !reflect.DeepEqual(got, want) {
+		t.Errorf("incremental standings differ from loaded standings\ngot:  %+v\nwant: %+v", got, want)
+	}
+}
+
+// 非同期の判定では、提出は ID 順に判定待ちとして反映され、判定は順不同で届く
+// 読み込み直しの前後で同じ提出が二重に届いてもよい
+// 提出を無効にすると読み込み直すので、無効にした提出は差分として届かない
+func TestStandingsOutOfOrderAsyncVerdicts(t *testing.T) {
+	setteststandingstiebreak(t)
+
+	want := loadteststandingsstate().build()
+	submissions := []Submission{}
+	for _, submission := range teststandingssubmissions {
+		if submission.Verdict != verdictinvalid {
+			submissions = append(submissions, submission)
+		}
+	}
+	for seed := int64(0); seed < 20; seed++ {
+		random := rand.New(rand.NewSource(seed))
+
+		s := newteststandingsstate()
+		for _, submission := range submissions {
+			pending := submission
+			pending.SubtaskID, pending.Score, pending.Verdict = nullsubtaskid, 0, verdictpending
+			s.applysubmission(pending)
+		}
+		judged := append([]Submission{}, submissions...)
+		random.Shuffle(len(judged), func(i, j int) {
+			judged[i], judged[j] = judged[j], judged[i]
+		})
+		unlocked := 0
+		for i, submission := range judged {
+			s.applysubmission(submission)
+			if i%5 == 0 && unlocked < len(teststandingsunlocks) {
+				unlock := teststandingsunlocks[unlocked]
+				s.applyhintunlock(unlock.teamID, teststandingshint(unlock.hintID))
+				unlocked++
+			}
+		}
+		for _, unlock := range teststandingsunlocks[unlocked:] {
+			s.applyhintunlock(unlock.teamID, teststandingshint(unlock.hintID))
+		}
+		for _, submission := range judged[:len(judged)/2] {
+			s.applysubmission(submission)
+		}
+
+		if got := s.build(); !reflect.DeepEqual(got, want) {
+			t.Errorf("seed %d: out-of-order standings differ from loaded standings\ngot:  %+v\nwant: %+v", seed, got, want)
+		}
+	}
+}
+
+func TestStandingsScores(t *testing.T) {
+	setteststandingstiebreak(t)
+
+	standings := loadteststandingsstate().build()
+
+	// beta は 2 チームが解いたので 500 + (100 - 500) * 2^2 / 3^2 = 323 点
+	if got := standings.TasksData[1].MaxScore; got != 323 {
+		t.Errorf("beta max score = %d, want 323", got)
+	}
+
+	type want struct {
+		rank                   int
+		totalscore             int
+		alphascore, betascore  int
+		alphafirst, betafirst  bool
+		lastscoreat            time.Time
+		wrongs, penaltyminutes int
+		hintsused              int
+	}
+	wants := map[string]want{
+		// alpha は 150 点からヒントの 30 点を引く
+		"apple": {rank: 1, totalscore: 443, alphascore: 120, betascore: 323, alphafirst: true, lastscoreat: teststandingsminute(35), hintsused: 1},
+		// beta の部分点 50 / 100 を 323 点に換算する。最後の得点より後の不正解はペナルティに数えない
+		"banana": {rank: 2, totalscore: 261, alphascore: 100, betascore: 161, lastscoreat: teststandingsminute(30), wrongs: 1, penaltyminutes: 20},
+		// beta はヒントのコストが得点を超えるので 0 点にする。得点しなかった beta への不正解はペナルティに数えない
+		"cherry": {rank: 3, totalscore: 50, alphascore: 50, betascore: 0, alphafirst: true, betafirst: true, lastscoreat: teststandingsminute(25), hintsused: 1},
+	}
+	for _, row := range standings.StandingsData {
+		w, ok := wants[row.TeamName]
+		if !ok {
+			t.Errorf("unexpected team %q", row.TeamName)
+			continue
+		}
+		if row.Rank != w.rank || row.TotalScore != w.totalscore {
+			t.Errorf("%s: rank %d total %d, want rank %d total %d", row.TeamName, row.Rank, row.TotalScore, w.rank, w.totalscore)
+		}
+		if row.ScoringData[0].Score != w.alphascore || row.ScoringData[1].Score != w.betascore {
+			t.Errorf("%s: task scores %d, %d, want %d, %d", row.TeamName, row.ScoringData[0].Score, row.ScoringData[1].Score, w.alphascore, w.betascore)
+		}
+		if row.ScoringData[0].FirstSolve != w.alphafirst || row.ScoringData[1].FirstSolve != w.betafirst {
+			t.Errorf("%s: first solves %t, %t, want %t, %t", row.TeamName, row.ScoringData[0].FirstSolve, row.ScoringData[1].FirstSolve, w.alphafirst, w.betafirst)
+		}
+		if row.HintsUsed != w.hintsused {
+			t.Errorf("%s: hints used %d, want %d", row.TeamName, row.HintsUsed, w.hintsused)
+		}
+		if row.Tiebreak == nil {
+			t.Errorf("%s: tiebreak is nil", row.TeamName)
+			continue
+		}
+		if row.Tiebreak.LastScoreAt != w.lastscoreat.Unix() || row.Tiebreak.WrongSubmissions != w.wrongs || row.Tiebreak.PenaltyMinutes != w.penaltyminutes {
+			t.Errorf("%s: tiebreak %+v, want last score at %d, %d wrongs, %d penalty minutes", row.TeamName, *row.Tiebreak, w.lastscoreat.Unix(), w.wrongs, w.penaltyminutes)
+		}
+		if row.Tiebreak.Value != w.lastscoreat.Unix()+int64(w.penaltyminutes)*60 {
+			t.Errorf("%s: tiebreak value %d, want %d", row.TeamName, row.Tiebreak.Value, w.lastscoreat.Unix()+int64(w.penaltyminutes)*60)
+		}
+	}
+}
+
+// 同点のチームは、ペナルティを加えた最後の得点の時刻で順位を付ける
+func TestStandingsTiebreakPenalty(t *testing.T) {
+	setteststandingstiebreak(t)
+
+	s := newteststandingsstate()
+	// apple は 5 分に、banana は 10 分に alpha-1 を解くが、apple は先に不正解を 1 回出している
+	s.applysubmission(teststandingssubmission(1, 1, 1, nullsubtaskid, nullsubtaskid, 0, verdictwrong, 1))
+	s.applysubmission(teststandingssubmission(2, 1, 1, nullsubtaskid, 1, 100, verdictaccepted, 5))
+	s.applysubmission(teststandingssubmission(3, 3, 1, nullsubtaskid, 1, 100, verdictaccepted, 10))
+
+	standings := s.build()
+	if got := []string{standings.StandingsData[0].TeamName, standings.StandingsData[1].TeamName}; !reflect.DeepEqual(got, []string{"banana", "apple"}) {
+		t.Errorf("order = %v, want [banana apple]", got)
+	}
+
+	// ペナルティがなければ先に解いた apple が上位
+	tiebreakpenaltyminutes = 0
+	s.rebuildall()
+	standings = s.build()
+	if got := []string{standings.StandingsData[0].TeamName, standings.StandingsData[1].TeamName}; !reflect.DeepEqual(got, []string{"apple", "banana"}) {
+		t.Errorf("order without penalty = %v, want [apple banana]", got)
+	}
+}
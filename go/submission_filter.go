@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultsubmissionsperpage = 20
+	maxsubmissionsperpage     = 100
+)
+
+// 提出の一覧の絞り込み条件。submissionsquery の WHERE 以降に使う
+type submissionfilter struct {
+	conditions []string
+	params     []interface{}
+}
+
+func (f *submissionfilter) add(condition string, params ...interface{}) {
+	f.conditions = append(f.conditions, condition)
+	f.params = append(f.params, params...)
+}
+
+func (f submissionfilter) where() string {
+	if len(f.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(f.conditions, " AND ")
+}
+
+// UNIX 時間 (秒) のクエリパラメータを読む。指定がなければ ok = false
+func parseunixparam(c echo.Context, name string) (time.Time, bool, error) {
+	if c.QueryParam(name) == "" {
+		return time.Time{}, false, nil
+	}
+	sec, err := strconv.ParseInt(c.QueryParam(name), 10, 64)
+	if err != nil {
+		return time.Time{}, false, echo.NewHTTPError(http.StatusBadRequest, "failed to parse "+name+": "+err.Error())
+	}
+	return time.Unix(sec, 0), true, nil
+}
+
+// クエリパラメータから提出の絞り込み条件を作る
+// admin 以外は自分のチームの提出に限る
+func parsesubmissionfilter(c echo.Context) (submissionfilter, error) {
+	ctx := c.Request().Context()
+
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	username, _ := sess.Values[defaultSessionUserNameKey].(string)
+
+	user := User{}
+	if err := dbConn.GetContext(ctx, &user, "SELECT * FROM users WHERE name = ?", username); err != nil {
+		return submissionfilter{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+	}
+
+	team := Team{}
+	if username != "admin" {
+		err := dbConn.GetContext(ctx, &team, "SELECT * FROM teams WHERE leader_id = ? OR member1_id = ? OR member2_id = ?", user.ID, user.ID, user.ID)
+		if err == sql.ErrNoRows {
+			return submissionfilter{}, echo.NewHTTPError(http.StatusBadRequest, "you have not joined team")
+		} else if err != nil {
+			return submissionfilter{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get team: "+err.Error())
+		}
+	} else if c.QueryParam("team_name") != "" {
+		err := dbConn.GetContext(ctx, &team, "SELECT * FROM teams WHERE name = ?", c.QueryParam("team_name"))
+		if err == sql.ErrNoRows {
+			return submissionfilter{}, echo.NewHTTPError(http.StatusBadRequest, "team not found")
+		} else if err != nil {
+			return submissionfilter{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get team: "+err.Error())
+		}
+	}
+
+	f := submissionfilter{
+		conditions: []string{},
+		params:     []interface{}{},
+	}
+
+	if c.QueryParam("task_name") != "" {
+		task := Task{}
+		err := dbConn.GetContext(ctx, &task, "SELECT * FROM tasks WHERE name = ?", c.QueryParam("task_name"))
+		if err == sql.ErrNoRows {
+			return submissionfilter{}, echo.NewHTTPError(http.StatusBadRequest, "task not found")
+		} else if err != nil {
+			return submissionfilter{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get task: "+err.Error())
+		}
+		f.add("submissions.task_id = ?", task.ID)
+	}
+	if c.QueryParam("subtask_name") != "" {
+		f.add("submissions.subtask_id IN (SELECT id FROM subtasks WHERE name = ?)", c.QueryParam("subtask_name"))
+	}
+	if c.QueryParam("user_name") != "" {
+		user := User{}
+		err := dbConn.GetContext(ctx, &user, "SELECT * FROM users WHERE name = ?", c.QueryParam("user_name"))
+		if err == sql.ErrNoRows {
+			return submissionfilter{}, echo.NewHTTPError(http.StatusBadRequest, "user not found")
+		} else if err != nil {
+			return submissionfilter{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+		}
+		f.add("submissions.user_id = ?", user.ID)
+	}
+	if c.QueryParam("filter") != "" {
+		f.add("submissions.answer LIKE CONCAT('%', ?, '%')", c.QueryParam("filter"))
+	}
+	// verdict はカンマ区切りで複数指定できる
+	if c.QueryParam("verdict") != "" {
+		query, args, err := sqlx.In("submissions.verdict IN (?)", strings.Split(c.QueryParam("verdict"), ","))
+		if err != nil {
+			return submissionfilter{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to build query: "+err.Error())
+		}
+		f.add(query, args...)
+	}
+	for _, bound := range []struct {
+		param     string
+		condition string
+	}{
+		{"min_score", "submissions.score >= ?"},
+		{"max_score", "submissions.score <= ?"},
+	} {
+		if c.QueryParam(bound.param) == "" {
+			continue
+		}
+		score, err := strconv.Atoi(c.QueryParam(bound.param))
+		if err != nil {
+			return submissionfilter{}, echo.NewHTTPError(http.StatusBadRequest, "failed to parse "+bound.param+": "+err.Error())
+		}
+		f.add(bound.condition, score)
+	}
+	// since 以降、until より前に提出されたもの
+	if since, ok, err := parseunixparam(c, "since"); err != nil {
+		return submissionfilter{}, err
+	} else if ok {
+		f.add("submissions.submitted_at >= ?", since)
+	}
+	if until, ok, err := parseunixparam(c, "until"); err != nil {
+		return submissionfilter{}, err
+	} else if ok {
+		f.add("submissions.submitted_at < ?", until)
+	}
+
+	if username != "admin" || c.QueryParam("team_name") != "" {
+		query, args, err := sqlx.In("submissions.user_id IN (?)", teammemberids(team))
+		if err != nil {
+			return submissionfilter{}, echo.NewHTTPError(http.StatusInternalServerError, "failed to build query: "+err.Error())
+		}
+		f.add(query, args...)
+	}
+
+	return f, nil
+}
+
+// 提出の一覧の並び順
+type submissionorder struct {
+	Sort string `json:"sort"` // submitted_at または score
+	Desc bool   `json:"desc"`
+}
+
+func (o submissionorder) column() string {
+	if o.Sort == "score" {
+		return "submissions.score"
+	}
+	return "submissions.submitted_at"
+}
+
+func (o submissionorder) orderby() string {
+	direction := " ASC"
+	if o.Desc {
+		direction = " DESC"
+	}
+	return " ORDER BY " + o.column() + direction + ", submissions.id" + direction
+}
+
+// sort, order のクエリパラメータを読む。デフォルトは新しい順
+func parsesubmissionorder(c echo.Context) (submissionorder, error) {
+	o := submissionorder{Sort: "submitted_at", Desc: true}
+	switch c.QueryParam("sort") {
+	case "", "submitted_at":
+	case "score":
+		o.Sort = "score"
+	default:
+		return submissionorder{}, echo.NewHTTPError(http.StatusBadRequest, "sort must be submitted_at or score")
+	}
+	switch c.QueryParam("order") {
+	case "", "desc":
+	case "asc":
+		o.Desc = false
+	default:
+		return submissionorder{}, echo.NewHTTPError(http.StatusBadRequest, "order must be asc or desc")
+	}
+	return o, nil
+}
+
+// 次のページの先頭を表すカーソル。最後に返した提出の並び替えの値と ID を持つ
+type submissioncursor struct {
+	Order submissionorder `json:"order"`
+	Value int64           `json:"value"` // submitted_at の場合は UNIX 時間 (秒)
+	ID    int             `json:"id"`
+}
+
+func encodesubmissioncursor(cursor submissioncursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodesubmissioncursor(s string) (submissioncursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return submissioncursor{}, err
+	}
+	cursor := submissioncursor{}
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return submissioncursor{}, err
+	}
+	return cursor, nil
+}
+
+// カーソルより後の提出に絞り込む条件を足す
+func (f *submissionfilter) after(cursor submissioncursor) {
+	op := ">"
+	if cursor.Order.Desc {
+		op = "<"
+	}
+	var value interface{} = cursor.Value
+	if cursor.Order.Sort != "score" {
+		value = time.Unix(cursor.Value, 0)
+	}
+	column := cursor.Order.column()
+	f.add("("+column+" "+op+" ? OR ("+column+" = ? AND submissions.id "+op+" ?))", value, value, cursor.ID)
+}
+
+// ページの大きさを読む
+func parsesubmissionlimit(c echo.Context) (int, error) {
+	if c.QueryParam("limit") == "" {
+		return defaultsubmissionsperpage, nil
+	}
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "failed to parse limit: "+err.Error())
+	}
+	if limit < 1 || limit > maxsubmissionsperpage {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxsubmissionsperpage))
+	}
+	return limit, nil
+}
@@ -6,20 +6,34 @@ import (
 	"net/http"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 )
 
 type Team struct {
-	ID             int    `db:"id"`
-	Name           string `db:"name"`
-	DisplayName    string `db:"display_name"`
-	LeaderID       int    `db:"leader_id"`
-	Member1ID      int    `db:"member1_id"`
-	Member2ID      int    `db:"member2_id"`
-	Description    string `db:"description"`
-	InvitationCode string `db:"invitation_code"`
+	ID             int       `db:"id"`
+	Name           string    `db:"name"`
+	DisplayName    string    `db:"display_name"`
+	LeaderID       int       `db:"leader_id"`
+	Member1ID      int       `db:"member1_id"`
+	Member2ID      int       `db:"member2_id"`
+	Description    string    `db:"description"`
+	InvitationCode string    `db:"invitation_code"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+// チームに所属するユーザーの ID を返す
+func teammemberids(team Team) []int {
+	ids := []int{team.LeaderID}
+	if team.Member1ID != nulluserid {
+		ids = append(ids, team.Member1ID)
+	}
+	if team.Member2ID != nulluserid {
+		ids = append(ids, team.Member2ID)
+	}
+	return ids
 }
 
 type CreateTeamRequest struct {
@@ -91,13 +105,14 @@ func createTeamHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get team: "+err.Error())
 	}
 
-	if _, err = tx.ExecContext(ctx, "INSERT INTO teams (name, display_name, leader_id, member1_id, member2_id, description, invitation_code) VALUES (?, ?, ?, ?, ?, ?, ?)", req.Name, req.DisplayName, req.leader_id, nulluserid, nulluserid, req.Description, req.InvitationCode); err != nil {
+	if _, err = tx.ExecContext(ctx, "INSERT INTO teams (name, display_name, leader_id, member1_id, member2_id, description, invitation_code, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", req.Name, req.DisplayName, req.leader_id, nulluserid, nulluserid, req.Description, req.InvitationCode, time.Now()); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert team: "+err.Error())
 	}
 
 	if err = tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit transaction: "+err.Error())
 	}
+	standingsengine.invalidate()
 
 	return c.NoContent(http.StatusCreated)
 }
@@ -176,6 +191,7 @@ func joinTeamHandler(c echo.Context) error {
 	if err = tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit transaction: "+err.Error())
 	}
+	standingsengine.invalidate()
 
 	return c.JSON(http.StatusCreated, JoinTeamResponse{
 		TeamName:        team.Name,
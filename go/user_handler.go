@@ -96,6 +96,10 @@ func registerHandler(c echo.Context) error {
 	if err = tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit transaction: "+err.Error())
 	}
+	// チームに入っていないユーザーも順位表に載せる場合は、新しいユーザーを読み込ませる
+	if userstandingsincludeteamless {
+		standingsengine.invalidate()
+	}
 
 	return c.NoContent(http.StatusCreated)
 }
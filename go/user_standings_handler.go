@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 1 の場合、チームに入っていないユーザーもユーザーの順位表に載せる
+var userstandingsincludeteamless = getEnv("RISUCON_USER_STANDINGS_INCLUDE_TEAMLESS", "0") == "1"
+
+const (
+	defaultusersperpage = 50
+	maxusersperpage     = 200
+)
+
+type UserStandings struct {
+	Rank            int       `json:"rank"`
+	UserName        string    `json:"user_name"`
+	UserDisplayName string    `json:"user_display_name"`
+	TeamName        string    `json:"team_name,omitempty"`
+	TeamDisplayName string    `json:"team_display_name,omitempty"`
+	TotalScore      int       `json:"total_score"`
+	Tiebreak        *Tiebreak `json:"tiebreak,omitempty"`
+}
+
+type UserStandingsResponse struct {
+	Users     []UserStandings `json:"users"`
+	UserCount int             `json:"user_count"`
+}
+
+// ユーザーごとの順位表。得点は自分の提出の最高得点だけで数え、チームのヒントのコストは引かない
+func (s *standingsstate) buildusers(includeteamless bool) []UserStandings {
+	teams := map[int]Team{}
+	for _, team := range s.teams {
+		teams[team.ID] = team
+	}
+
+	rows := []UserStandings{}
+	for _, user := range s.users {
+		if user.Name == "admin" {
+			continue
+		}
+		teamID, hasteam := s.teamofuser[user.ID]
+		if !hasteam && !includeteamless {
+			continue
+		}
+
+		row := UserStandings{
+			UserName:        user.Name,
+			UserDisplayName: user.DisplayName,
+		}
+		if hasteam {
+			row.TeamName = teams[teamID].Name
+			row.TeamDisplayName = teams[teamID].DisplayName
+		}
+
+		lastscoreat := time.Time{}
+		// 得点した問題への不正解の提出の時刻
+		wrongs := []time.Time{}
+		for _, task := range s.tasks {
+			taskscore := 0
+			for _, subtask := range s.subtaskspertask[task.ID] {
+				userscore, ok := s.userscores[user.ID][subtask.ID]
+				if !ok {
+					continue
+				}
+				score := userscore.score
+				if task.isdynamic() {
+					score = scaledynamicscore(score, s.basemaxscores[subtask.ID], s.subtaskmaxscore(subtask))
+				}
+				taskscore += score
+				if score > 0 && userscore.at.After(lastscoreat) {
+					lastscoreat = userscore.at
+				}
+			}
+			row.TotalScore += taskscore
+			if taskscore > 0 {
+				wrongs = append(wrongs, s.wrongtimes([]int{user.ID}, task.ID)...)
+			}
+		}
+		if tiebreak != tiebreaknone {
+			row.Tiebreak = newtiebreak(lastscoreat, wrongs)
+		}
+		rows = append(rows, row)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rankless(rows[i].TotalScore, rows[i].Tiebreak, rows[i].UserName, rows[j].TotalScore, rows[j].Tiebreak, rows[j].UserName)
+	})
+	for i := range rows {
+		if i > 0 && rankequal(rows[i].TotalScore, rows[i].Tiebreak, rows[i-1].TotalScore, rows[i-1].Tiebreak) {
+			rows[i].Rank = rows[i-1].Rank
+		} else {
+			rows[i].Rank = i + 1
+		}
+	}
+	return rows
+}
+
+// ユーザーの順位表を返す。frozen なら凍結時刻の時点のものを返す
+func (e *standingsengineT) getusers(ctx context.Context, frozen bool) ([]UserStandings, error) {
+	if _, err := e.get(ctx, false); err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.live == nil {
+		if err := e.load(ctx); err != nil {
+			e.clear()
+			return nil, err
+		}
+		e.publish()
+	}
+	if frozen && e.frozen != nil {
+		if e.frozenusers == nil {
+			e.frozenusers = e.frozen.buildusers(userstandingsincludeteamless)
+		}
+		return e.frozenusers, nil
+	}
+	if e.liveusers == nil {
+		e.liveusers = e.live.buildusers(userstandingsincludeteamless)
+	}
+	return e.liveusers, nil
+}
+
+// GET /api/standings/users
+// ユーザーごとの順位表。page (1-idx) と limit でページを指定する
+func getUserStandingsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	limit := defaultusersperpage
+	if c.QueryParam("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(c.QueryParam("limit"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "failed to parse limit: "+err.Error())
+		}
+		if limit < 1 || limit > maxusersperpage {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxusersperpage))
+		}
+	}
+	page := 1
+	if c.QueryParam("page") != "" {
+		var err error
+		page, err = strconv.Atoi(c.QueryParam("page"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "failed to parse page: "+err.Error())
+		}
+		if page < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, "page must be positive")
+		}
+	}
+
+	// 凍結中は凍結時刻の時点の順位表を見せる
+	frozen := false
+	if verifyAdminSession(c) != nil {
+		until, err := standingsengine.publicuntil(ctx, time.Now())
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get standings: "+err.Error())
+		}
+		frozen = !until.IsZero()
+	}
+
+	users, err := standingsengine.getusers(ctx, frozen)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user standings: "+err.Error())
+	}
+
+	res := UserStandingsResponse{Users: []UserStandings{}, UserCount: len(users)}
+	// 大きすぎる page で (page-1)*limit が溢れないよう、掛ける前に範囲外のページを除く
+	if page-1 <= len(users)/limit {
+		start := min((page-1)*limit, len(users))
+		end := min(start+limit, len(users))
+		res.Users = append(res.Users, users[start:end]...)
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// VARCHAR(255) のカラムに入る長さ
+const maxnamelength = 255
+
+type ValidationError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+type ValidationErrorResponse struct {
+	Message string            `json:"message"`
+	Errors  []ValidationError `json:"errors"`
+}
+
+type validator struct {
+	errors []ValidationError
+}
+
+func (v *validator) add(field string, code string, message string) {
+	v.errors = append(v.errors, ValidationError{
+		Field:   field,
+		Code:    code,
+		Message: message,
+	})
+}
+
+// 空でなく、VARCHAR(255) に収まる文字列であることを確認する
+func (v *validator) name(field string, value string) {
+	if value == "" {
+		v.add(field, "required", field+" is required")
+	} else if utf8.RuneCountInString(value) > maxnamelength {
+		v.add(field, "too_long", fmt.Sprintf("%s must be at most %d characters", field, maxnamelength))
+	}
+}
+
+// 空でない場合、登録されている checker の名前であることを確認する
+func (v *validator) checker(field string, value string) {
+	if value == "" {
+		return
+	}
+	if filename, ok := strings.CutPrefix(value, externalcheckerprefix); ok {
+		if _, err := externalcheckerpath(filename); err != nil {
+			v.add(field, "checker_not_found", "external checker is not available: "+err.Error())
+		}
+		return
+	}
+	if _, ok := getchecker(value); !ok {
+		v.add(field, "unknown_checker", fmt.Sprintf("checker %q is not registered (available: %s)", value, strings.Join(checkernames(), ", ")))
+	}
+}
+
+// 得点の減衰の設定を確認する
+func (v *validator) decay(field string, decay DecayPolicy) {
+	switch decay.Type {
+	case decaynone:
+		return
+	case decaylinear, decaystep:
+	default:
+		v.add(field+".type", "unknown_decay", fmt.Sprintf("decay type must be %q or %q", decaylinear, decaystep))
+	}
+	if decay.IntervalMinutes <= 0 {
+		v.add(field+".interval_minutes", "not_positive", "interval_minutes must be positive")
+	}
+	if decay.Percent <= 0 || decay.Percent > 100 {
+		v.add(field+".percent", "out_of_range", "percent must be between 1 and 100")
+	}
+	if decay.FloorPercent < 0 || decay.FloorPercent > 100 {
+		v.add(field+".floor_percent", "out_of_range", "floor_percent must be between 0 and 100")
+	}
+}
+
+// 動的配点の設定を確認する
+func (v *validator) dynamic(field string, dynamic DynamicScoring) {
+	if dynamic.Initial <= 0 {
+		v.add(field+".initial", "not_positive", "initial must be positive")
+	}
+	if dynamic.Minimum < 0 || dynamic.Minimum > dynamic.Initial {
+		v.add(field+".minimum", "out_of_range", "minimum must be between 0 and initial")
+	}
+	if dynamic.Decay <= 0 {
+		v.add(field+".decay", "not_positive", "decay must be positive")
+	}
+}
+
+// 問題作成のリクエストを検証し、見つかった問題をすべて返す
+// DB を参照する検証 (既存の問題との重複など) は含まない
+func validatecreatetaskrequest(req CreateTaskRequest) []ValidationError {
+	v := validator{errors: []ValidationError{}}
+
+	v.name("name", req.Name)
+	v.name("display_name", req.DisplayName)
+	if req.SubmissionLimit <= 0 {
+		v.add("submission_limit", "not_positive", "submission_limit must be positive")
+	}
+	v.checker("checker", req.Checker)
+	if req.Decay != nil {
+		v.decay("decay", *req.Decay)
+	}
+	if req.Dynamic != nil {
+		v.dynamic("dynamic", *req.Dynamic)
+	}
+
+	for i, cooldown := range req.Cooldowns {
+		cooldownfield := fmt.Sprintf("cooldowns[%d]", i)
+
+		if cooldown.MaxSubmissions <= 0 {
+			v.add(cooldownfield+".max_submissions", "not_positive", "max_submissions must be positive")
+		}
+		if cooldown.WindowMinutes <= 0 {
+			v.add(cooldownfield+".window_minutes", "not_positive", "window_minutes must be positive")
+		}
+	}
+
+	if len(req.Subtasks) == 0 {
+		v.add("subtasks", "required", "at least one subtask is required")
+	}
+
+	subtasknames := map[string]string{}
+	// answers テーブルの uniq_answer (task_id, answer) に合わせて、問題全体で答えの重複を確認する
+	answers := map[string]string{}
+	for i, subtask := range req.Subtasks {
+		subtaskfield := fmt.Sprintf("subtasks[%d]", i)
+
+		v.name(subtaskfield+".name", subtask.Name)
+		v.name(subtaskfield+".display_name", subtask.DisplayName)
+		if other, ok := subtasknames[subtask.Name]; ok && subtask.Name != "" {
+			v.add(subtaskfield+".name", "duplicate", fmt.Sprintf("subtask name %q is already used by %s", subtask.Name, other))
+		} else {
+			subtasknames[subtask.Name] = subtaskfield
+		}
+
+		if subtask.AttemptLimit < 0 {
+			v.add(subtaskfield+".attempt_limit", "negative_limit", "attempt_limit must not be negative")
+		}
+
+		v.checker(subtaskfield+".checker", subtask.Checker)
+		if subtask.MaxScore < 0 {
+			v.add(subtaskfield+".max_score", "negative_score", "max_score must not be negative")
+		}
+		// 完全一致で判定する小課題には答えが必要
+		checkername := subtask.Checker
+		if checkername == "" {
+			checkername = req.Checker
+		}
+		if (checkername == "" || checkername == defaultcheckername) && len(subtask.Answers) == 0 {
+			v.add(subtaskfield+".answers", "no_answers", "subtask must have at least one answer")
+		}
+		// checker で判定する小課題は満点が 0 だとどの得点も満点扱いになるので、満点が必要
+		if checkername != "" && checkername != defaultcheckername && subtask.MaxScore <= 0 {
+			v.add(subtaskfield+".max_score", "not_positive", "max_score must be positive when a checker is set")
+		}
+		for j, answer := range subtask.Answers {
+			answerfield := fmt.Sprintf("%s.answers[%d]", subtaskfield, j)
+
+			v.name(answerfield+".answer", answer.Answer)
+			if other, ok := answers[answer.Answer]; ok && answer.Answer != "" {
+				v.add(answerfield+".answer", "duplicate_answer", fmt.Sprintf("answer %q is already used by %s", answer.Answer, other))
+			} else {
+				answers[answer.Answer] = answerfield
+			}
+			if answer.Score < 0 {
+				v.add(answerfield+".score", "negative_score", "score must not be negative")
+			}
+		}
+
+		for j, hint := range subtask.Hints {
+			hintfield := fmt.Sprintf("%s.hints[%d]", subtaskfield, j)
+
+			if hint.Content == "" {
+				v.add(hintfield+".content", "required", hintfield+".content is required")
+			}
+			if hint.Cost < 0 {
+				v.add(hintfield+".cost", "negative_cost", "cost must not be negative")
+			}
+		}
+	}
+
+	return v.errors
+}
@@ -20,6 +20,7 @@ CREATE TABLE `teams` (
     `member2_id` INT DEFAULT -1 NOT NULL,
     `description` TEXT NOT NULL,
     `invitation_code` VARCHAR(255) NOT NULL,
+    `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
     UNIQUE `uniq_team_name` (`name`)
 ) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
 
@@ -30,6 +31,15 @@ CREATE TABLE `tasks` (
     `display_name` VARCHAR(255) NOT NULL,
     `statement` TEXT NOT NULL,
     `submission_limit` INT NOT NULL,
+    `dedupe_answers` BOOLEAN DEFAULT FALSE NOT NULL,
+    `checker` VARCHAR(255) DEFAULT '' NOT NULL,
+    `decay_type` VARCHAR(255) DEFAULT '' NOT NULL,
+    `decay_interval_minutes` INT DEFAULT 0 NOT NULL,
+    `decay_percent` INT DEFAULT 0 NOT NULL,
+    `decay_floor_percent` INT DEFAULT 0 NOT NULL,
+    `dynamic_initial` INT DEFAULT 0 NOT NULL,
+    `dynamic_minimum` INT DEFAULT 0 NOT NULL,
+    `dynamic_decay` INT DEFAULT 0 NOT NULL,
     UNIQUE `uniq_task_name` (`name`)
 ) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
 
@@ -42,6 +52,9 @@ CREATE TABLE `subtasks` (
     `display_name` VARCHAR(255) NOT NULL,
     `task_id` INT NOT NULL,
     `statement` TEXT NOT NULL,
+    `attempt_limit` INT DEFAULT 0 NOT NULL,
+    `checker` VARCHAR(255) DEFAULT '' NOT NULL,
+    `max_score` INT DEFAULT 0 NOT NULL,
     UNIQUE `uniq_question` (`task_id`, `name`)
 ) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
 
@@ -65,10 +78,16 @@ CREATE TABLE `submissions` (
     `task_id` INT NOT NULL,
     `user_id` INT NOT NULL,
     `submitted_at` DATETIME NOT NULL,
-    `answer` VARCHAR(255) NOT NULL
+    `answer` VARCHAR(255) NOT NULL,
+    `target_subtask_id` INT DEFAULT -1 NOT NULL,
+    `subtask_id` INT DEFAULT -1 NOT NULL,
+    `score` INT DEFAULT 0 NOT NULL,
+    `verdict` VARCHAR(32) DEFAULT '' NOT NULL,
+    `client_submitted_at` DATETIME DEFAULT NULL
 ) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
 
 CREATE INDEX `idx_submissions` ON `submissions` (`task_id`, `user_id`, `submitted_at`);
+CREATE INDEX `idx_submissions_submitted_at` ON `submissions` (`submitted_at`, `id`);
 
 DROP TABLE IF EXISTS `subtask_scores_of_user`;
 CREATE TABLE `subtask_scores_of_user` (
@@ -77,4 +96,112 @@ CREATE TABLE `subtask_scores_of_user` (
     `score` INT DEFAULT 0 NOT NULL
 ) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
 
-CREATE INDEX `idx_subtask_scores_of_user` ON `subtask_scores_of_user` (`user_id`, `subtask_id`);
+CREATE UNIQUE INDEX `idx_subtask_scores_of_user` ON `subtask_scores_of_user` (`user_id`, `subtask_id`);
+
+DROP TABLE IF EXISTS `statement_revisions`;
+CREATE TABLE `statement_revisions` (
+    `id` INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    `task_id` INT NOT NULL,
+    `subtask_id` INT DEFAULT -1 NOT NULL,
+    `revision` INT NOT NULL,
+    `statement` TEXT NOT NULL,
+    `author_id` INT NOT NULL,
+    `created_at` DATETIME NOT NULL,
+    UNIQUE `uniq_statement_revision` (`task_id`, `revision`, `subtask_id`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+CREATE INDEX `idx_statement_revisions` ON `statement_revisions` (`created_at`);
+
+DROP TABLE IF EXISTS `hints`;
+CREATE TABLE `hints` (
+    `id` INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    `task_id` INT NOT NULL,
+    `subtask_id` INT NOT NULL,
+    `position` INT NOT NULL,
+    `content` TEXT NOT NULL,
+    `cost` INT NOT NULL,
+    UNIQUE `uniq_hint` (`subtask_id`, `position`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+CREATE INDEX `idx_hints` ON `hints` (`task_id`);
+
+DROP TABLE IF EXISTS `hint_unlocks`;
+CREATE TABLE `hint_unlocks` (
+    `id` INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    `team_id` INT NOT NULL,
+    `hint_id` INT NOT NULL,
+    `user_id` INT NOT NULL,
+    `unlocked_at` DATETIME NOT NULL,
+    UNIQUE `uniq_hint_unlock` (`team_id`, `hint_id`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+DROP TABLE IF EXISTS `submission_cooldowns`;
+CREATE TABLE `submission_cooldowns` (
+    `id` INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    `task_id` INT NOT NULL,
+    `max_submissions` INT NOT NULL,
+    `window_minutes` INT NOT NULL
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+CREATE INDEX `idx_submission_cooldowns` ON `submission_cooldowns` (`task_id`);
+
+DROP TABLE IF EXISTS `idempotency_keys`;
+CREATE TABLE `idempotency_keys` (
+    `id` INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    `user_id` INT NOT NULL,
+    `idem_key` VARCHAR(255) NOT NULL,
+    `request` TEXT NOT NULL,
+    `status` INT DEFAULT 0 NOT NULL,
+    `response` TEXT,
+    `created_at` DATETIME NOT NULL,
+    UNIQUE `uniq_idempotency_key` (`user_id`, `idem_key`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+DROP TABLE IF EXISTS `checker_failures`;
+CREATE TABLE `checker_failures` (
+    `id` INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    `submission_id` INT NOT NULL,
+    `task_id` INT NOT NULL,
+    `subtask_id` INT NOT NULL,
+    `checker` VARCHAR(255) NOT NULL,
+    `reason` VARCHAR(255) NOT NULL,
+    `detail` TEXT NOT NULL,
+    `occurred_at` DATETIME NOT NULL
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+CREATE INDEX `idx_checker_failures` ON `checker_failures` (`task_id`);
+
+DROP TABLE IF EXISTS `subtask_solves`;
+CREATE TABLE `subtask_solves` (
+    `subtask_id` INT NOT NULL,
+    `team_id` INT NOT NULL,
+    `solved_at` DATETIME NOT NULL,
+    PRIMARY KEY (`subtask_id`, `team_id`)
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+DROP TABLE IF EXISTS `standings_reveals`;
+CREATE TABLE `standings_reveals` (
+    `team_id` INT NOT NULL PRIMARY KEY,
+    `revealed_at` DATETIME NOT NULL
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+DROP TABLE IF EXISTS `first_solves`;
+CREATE TABLE `first_solves` (
+    `subtask_id` INT NOT NULL PRIMARY KEY,
+    `team_id` INT NOT NULL,
+    `user_id` INT NOT NULL,
+    `submission_id` INT NOT NULL,
+    `solved_at` DATETIME NOT NULL
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+DROP TABLE IF EXISTS `contest_events`;
+CREATE TABLE `contest_events` (
+    `id` INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    `event_key` VARCHAR(255) NOT NULL,
+    `type` VARCHAR(255) NOT NULL,
+    `op` VARCHAR(255) NOT NULL,
+    `data` TEXT NOT NULL,
+    `created_at` DATETIME NOT NULL
+) ENGINE=InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+CREATE INDEX `idx_contest_events_key` ON `contest_events` (`event_key`);
@@ -742,3 +742,9 @@ INSERT INTO `subtask_scores_of_user` (`user_id`, `subtask_id`, `score`) VALUES
 (51, 4, 100),
 (86, 10, 50),
 (37, 10, 50);
+TRUNCATE TABLE `statement_revisions`;
+ALTER TABLE `statement_revisions` AUTO_INCREMENT = 1;
+INSERT INTO `statement_revisions` (`task_id`, `subtask_id`, `revision`, `statement`, `author_id`, `created_at`)
+SELECT `id`, -1, 1, `statement`, 1, '2024-03-26 18:00:00' FROM `tasks`;
+INSERT INTO `statement_revisions` (`task_id`, `subtask_id`, `revision`, `statement`, `author_id`, `created_at`)
+SELECT `task_id`, `id`, 1, `statement`, 1, '2024-03-26 18:00:00' FROM `subtasks`;
@@ -0,0 +1,39 @@
+-- 判定結果が記録されていない提出 (verdict = '') に、現在の答えで判定した結果を埋める
+UPDATE `submissions`
+LEFT JOIN `answers` ON `answers`.`task_id` = `submissions`.`task_id` AND `answers`.`answer` = `submissions`.`answer`
+LEFT JOIN (SELECT `subtask_id`, MAX(`score`) AS `max_score` FROM `answers` GROUP BY `subtask_id`) AS `subtask_max` ON `subtask_max`.`subtask_id` = `answers`.`subtask_id`
+SET `submissions`.`subtask_id` = COALESCE(`answers`.`subtask_id`, -1),
+    `submissions`.`score` = COALESCE(`answers`.`score`, 0),
+    `submissions`.`verdict` = CASE
+        WHEN `answers`.`id` IS NULL THEN 'wrong'
+        WHEN `answers`.`score` >= `subtask_max`.`max_score` THEN 'accepted'
+        ELSE 'partial'
+    END
+WHERE `submissions`.`verdict` = '';
+
+-- 満点を取った提出から、チームごとに小課題を解いた時刻を埋める
+INSERT IGNORE INTO `subtask_solves` (`subtask_id`, `team_id`, `solved_at`)
+SELECT `submissions`.`subtask_id`, `teams`.`id`, MIN(`submissions`.`submitted_at`)
+FROM `submissions`
+JOIN `teams` ON `submissions`.`user_id` IN (`teams`.`leader_id`, `teams`.`member1_id`, `teams`.`member2_id`)
+WHERE `submissions`.`verdict` = 'accepted'
+GROUP BY `submissions`.`subtask_id`, `teams`.`id`;
+
+-- 小課題ごとに、満点を取った最初の提出のチームを埋める
+INSERT IGNORE INTO `first_solves` (`subtask_id`, `team_id`, `user_id`, `submission_id`, `solved_at`)
+SELECT `submissions`.`subtask_id`, `teams`.`id`, `submissions`.`user_id`, `submissions`.`id`, `submissions`.`submitted_at`
+FROM `submissions`
+JOIN `teams` ON `submissions`.`user_id` IN (`teams`.`leader_id`, `teams`.`member1_id`, `teams`.`member2_id`)
+WHERE `submissions`.`id` IN (
+    SELECT MIN(`id`) FROM `submissions` WHERE `verdict` = 'accepted' GROUP BY `subtask_id`
+);
+
+-- 初期データのチームは作成時刻を持たないので、メンバーの最初の提出の時刻で置き換える
+UPDATE `teams`
+JOIN (
+    SELECT `teams`.`id` AS `team_id`, MIN(`submissions`.`submitted_at`) AS `first_submitted_at`
+    FROM `teams`
+    JOIN `submissions` ON `submissions`.`user_id` IN (`teams`.`leader_id`, `teams`.`member1_id`, `teams`.`member2_id`)
+    GROUP BY `teams`.`id`
+) AS `first_submissions` ON `first_submissions`.`team_id` = `teams`.`id`
+SET `teams`.`created_at` = LEAST(`teams`.`created_at`, `first_submissions`.`first_submitted_at`);
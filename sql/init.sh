@@ -19,3 +19,9 @@ mysql -u"$RISUCON_DB_USER" \
 		--host "$RISUCON_DB_HOST" \
 		--port "$RISUCON_DB_PORT" \
 		"$RISUCON_DB_NAME" < 01_initial_data.sql
+
+mysql -u"$RISUCON_DB_USER" \
+		-p"$RISUCON_DB_PASSWORD" \
+		--host "$RISUCON_DB_HOST" \
+		--port "$RISUCON_DB_PORT" \
+		"$RISUCON_DB_NAME" < 02_backfill_submission_verdicts.sql